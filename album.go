@@ -0,0 +1,275 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fogleman/imview"
+)
+
+const (
+	googlePhotosAPI = "https://photoslibrary.googleapis.com/v1/mediaItems:search"
+	flickrAPI       = "https://api.flickr.com/services/rest/"
+	flickrPageSize  = 500
+)
+
+var (
+	flickrAlbumURL = regexp.MustCompile(`flickr\.com/photos/([^/]+)/(?:albums|sets)/(\d+)`)
+	googleAlbumURL = regexp.MustCompile(`photos\.google\.com/(?:u/\d+/)?album/([^/?#]+)`)
+)
+
+type albumPhoto struct {
+	name string
+	url  string
+}
+
+var albumClient = &http.Client{Timeout: time.Minute}
+
+// parseAlbum recognizes Flickr album URLs, Google Photos album URLs and
+// bare IDs prefixed with the service, as in flickr:72157... or google:AF1Q...
+func parseAlbum(ref string) (service string, user string, id string, err error) {
+	if m := flickrAlbumURL.FindStringSubmatch(ref); m != nil {
+		return "flickr", m[1], m[2], nil
+	}
+	if m := googleAlbumURL.FindStringSubmatch(ref); m != nil {
+		return "google", "", m[1], nil
+	}
+	if i := strings.Index(ref, ":"); i > 0 && (ref[:i] == "flickr" || ref[:i] == "google") {
+		return ref[:i], "", ref[i+1:], nil
+	}
+	return "", "", "", fmt.Errorf("album: cannot tell the service of %q; use a Flickr or Google Photos album URL, or flickr:ID / google:ID", ref)
+}
+
+func getJSON(req *http.Request, v interface{}) error {
+	resp, err := albumClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("album: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// googlePhotos lists the photos of an album through the Library API. token
+// is an OAuth access token with the photoslibrary.readonly scope. Videos are
+// skipped; "=d" asks for the original bytes.
+func googlePhotos(id string, token string) ([]albumPhoto, error) {
+	var photos []albumPhoto
+	pageToken := ""
+	for {
+		query, _ := json.Marshal(map[string]interface{}{"albumId": id, "pageSize": 100, "pageToken": pageToken})
+		req, err := http.NewRequest(http.MethodPost, googlePhotosAPI, bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+
+		var page struct {
+			MediaItems []struct {
+				BaseURL  string `json:"baseUrl"`
+				Filename string `json:"filename"`
+				MimeType string `json:"mimeType"`
+			} `json:"mediaItems"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getJSON(req, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.MediaItems {
+			if strings.HasPrefix(item.MimeType, "image/") {
+				photos = append(photos, albumPhoto{item.Filename, item.BaseURL + "=d"})
+			}
+		}
+		if page.NextPageToken == "" {
+			return photos, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// flickrPhotos lists a photoset with the public REST API, preferring the
+// original size and falling back to the large rendition when the owner
+// hides originals.
+func flickrPhotos(user string, id string, apiKey string) ([]albumPhoto, error) {
+	var photos []albumPhoto
+	for page := 1; ; page++ {
+		q := url.Values{
+			"method":         {"flickr.photosets.getPhotos"},
+			"api_key":        {apiKey},
+			"photoset_id":    {id},
+			"extras":         {"url_o,url_l"},
+			"per_page":       {fmt.Sprint(flickrPageSize)},
+			"page":           {fmt.Sprint(page)},
+			"format":         {"json"},
+			"nojsoncallback": {"1"},
+		}
+		// Album URLs may name the owner by path alias; the API only takes
+		// NSIDs such as 12345678@N00, and the owner is optional anyway.
+		if strings.Contains(user, "@N") {
+			q.Set("user_id", user)
+		}
+		req, err := http.NewRequest(http.MethodGet, flickrAPI+"?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var resp struct {
+			Stat     string `json:"stat"`
+			Message  string `json:"message"`
+			Photoset struct {
+				Photo []struct {
+					ID   string `json:"id"`
+					URLO string `json:"url_o"`
+					URLL string `json:"url_l"`
+				} `json:"photo"`
+			} `json:"photoset"`
+		}
+		if err := getJSON(req, &resp); err != nil {
+			return nil, err
+		}
+		if resp.Stat != "ok" {
+			return nil, errors.New("album: flickr: " + resp.Message)
+		}
+		for _, p := range resp.Photoset.Photo {
+			u := p.URLO
+			if u == "" {
+				u = p.URLL
+			}
+			if u != "" {
+				photos = append(photos, albumPhoto{p.ID + filepath.Ext(u), u})
+			}
+		}
+		if len(resp.Photoset.Photo) < flickrPageSize {
+			return photos, nil
+		}
+	}
+}
+
+// downloadPhotos saves the photos into dir, numbered so they keep the album
+// order, and returns their paths.
+func downloadPhotos(photos []albumPhoto, dir string) ([]string, error) {
+	paths := make([]string, len(photos))
+	for i, p := range photos {
+		resp, err := albumClient.Get(p.url)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("album: %s: %s", p.name, resp.Status)
+		}
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%04d-%s", i+1, filepath.Base(p.name)))
+		f, err := os.Create(paths[i])
+		if err == nil {
+			_, err = io.Copy(f, resp.Body)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+func albumMain(args []string) {
+	fs := flag.NewFlagSet("album", flag.ExitOnError)
+	configureLogging := addLogFlags(fs)
+	token := fs.String("token", "", "Google Photos OAuth access token or Flickr API key (default $GOOGLE_PHOTOS_TOKEN or $FLICKR_API_KEY)")
+	outputPath := fs.String("o", "", "write the collage to this file instead of showing it")
+	rows := fs.Int("rows", 0, "number of rows (default about the square root of the photo count)")
+	shape := fs.String("shape", string(RectangleShape), "tile shape: Rectangle or Circle")
+	keep := fs.String("keep", "", "directory to keep the downloaded photos in instead of a temporary one")
+	fs.Parse(args)
+	configureLogging()
+
+	if fs.NArg() != 1 {
+		logger.Fatal("Usage: imagecollager album [flags] <album URL or flickr:ID / google:ID>")
+	}
+	if ImageShape(*shape) != RectangleShape && ImageShape(*shape) != CircleShape {
+		logger.Fatalf("Unknown shape %q", *shape)
+	}
+	service, user, id, err := parseAlbum(fs.Arg(0))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	var photos []albumPhoto
+	switch service {
+	case "google":
+		if *token == "" {
+			*token = os.Getenv("GOOGLE_PHOTOS_TOKEN")
+		}
+		photos, err = googlePhotos(id, *token)
+	case "flickr":
+		if *token == "" {
+			*token = os.Getenv("FLICKR_API_KEY")
+		}
+		photos, err = flickrPhotos(user, id, *token)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if len(photos) == 0 {
+		logger.Fatal("album: no photos found")
+	}
+
+	dir := *keep
+	if dir == "" {
+		dir, err = ioutil.TempDir("", "imagecollager-album-")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.onFatal(func() { os.RemoveAll(dir) })
+		defer os.RemoveAll(dir)
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Fatal(err)
+	}
+	paths, err := downloadPhotos(photos, dir)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	opts := Options{width: 800, height: 800, rows: *rows, shape: ImageShape(*shape)}
+	if opts.rows < 1 {
+		opts.rows = int(math.Max(1, math.Round(math.Sqrt(float64(len(paths))))))
+	}
+	images := loadImages(opts, paths)
+	if opts.rows > len(images) {
+		logger.Warnf("Clamping %d rows to the %d photos of the album", opts.rows, len(images))
+		opts.rows = len(images)
+	}
+	output := makeImageCollage(opts, images...)
+	if *outputPath != "" {
+		if err := saveImage(*outputPath, output.value, Encoding{}); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+	imview.Show(output.value)
+}
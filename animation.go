@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/webp"
+)
+
+// FrameSelection picks the frame of an animated GIF or WebP used as a tile:
+// first, middle, last, a 1-based frame number, or all to make every frame
+// its own tile.
+type FrameSelection string
+
+const (
+	FrameFirst  FrameSelection = "first"
+	FrameMiddle FrameSelection = "middle"
+	FrameLast   FrameSelection = "last"
+	FrameAll    FrameSelection = "all"
+)
+
+func parseFrameSelection(s string) (FrameSelection, error) {
+	switch sel := FrameSelection(s); sel {
+	case FrameFirst, FrameMiddle, FrameLast, FrameAll:
+		return sel, nil
+	}
+	if n, err := strconv.Atoi(s); err != nil || n < 1 {
+		return "", fmt.Errorf("invalid frame %q, expected first, middle, last, all or a frame number", s)
+	}
+	return FrameSelection(s), nil
+}
+
+// frameIndex resolves sel against an animation of n frames. All resolves to
+// the first frame for paths that were not expanded.
+func (sel FrameSelection) frameIndex(n int) (int, error) {
+	switch sel {
+	case "", FrameFirst, FrameAll:
+		return 0, nil
+	case FrameMiddle:
+		return n / 2, nil
+	case FrameLast:
+		return n - 1, nil
+	}
+	i, err := strconv.Atoi(string(sel))
+	if err != nil || i < 1 || i > n {
+		return 0, fmt.Errorf("frame %s outside 1-%d", sel, n)
+	}
+	return i - 1, nil
+}
+
+// splitFramePath separates a "anim.gif#frame=3" path from its frame number.
+func splitFramePath(path string) (string, FrameSelection) {
+	if i := strings.LastIndex(path, "#frame="); i >= 0 {
+		return path[:i], FrameSelection(path[i+7:])
+	}
+	return path, ""
+}
+
+func isAnimated(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("GIF8")) ||
+		len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+func isAnimationPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".gif" || ext == ".webp"
+}
+
+// decodeFrames composites the frames of a GIF or WebP as they would be
+// displayed, up to the frame sel selects, and returns that frame. FrameAll
+// returns every frame instead. Still images yield a single frame.
+func decodeFrames(data []byte, sel FrameSelection) ([]image.Image, error) {
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		return decodeGIFFrames(data, sel)
+	}
+	return decodeWebPFrames(data, sel)
+}
+
+// lastFrame is the index compositing stops at for sel among n frames.
+func (sel FrameSelection) lastFrame(n int) (int, error) {
+	if n < 1 {
+		return 0, errors.New("animation has no frames")
+	}
+	if sel == FrameAll {
+		return n - 1, nil
+	}
+	return sel.frameIndex(n)
+}
+
+func decodeGIFFrames(data []byte, sel FrameSelection) ([]image.Image, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	last, err := sel.lastFrame(len(g.Image))
+	if err != nil {
+		return nil, err
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	var frames []image.Image
+	for i, frame := range g.Image[:last+1] {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if sel == FrameAll || i == last {
+			frames = append(frames, cloneRGBA(canvas))
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames, nil
+}
+
+// webpChunks calls f with the id and payload of each top-level chunk of a
+// WebP file, stopping at the first error.
+func webpChunks(data []byte, f func(id string, payload []byte) error) error {
+	for body := data[12:]; len(body) >= 8; {
+		id, size := string(body[:4]), int(binary.LittleEndian.Uint32(body[4:8]))
+		if size < 0 || 8+size > len(body) {
+			return errors.New("webp: truncated chunk " + id)
+		}
+		if err := f(id, body[8:8+size]); err != nil {
+			return err
+		}
+		body = body[8+size+size%2:]
+	}
+	return nil
+}
+
+// decodeWebPFrames composites the ANMF frames of an animated WebP. Each
+// frame's bitstream is rewrapped as a standalone extended WebP so that
+// x/image/webp, which does not understand animation, can decode it.
+func decodeWebPFrames(data []byte, sel FrameSelection) ([]image.Image, error) {
+	count := 0
+	if err := webpChunks(data, func(id string, payload []byte) error {
+		if id == "ANMF" {
+			count++
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		if _, err := sel.lastFrame(1); err != nil {
+			return nil, err
+		}
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return []image.Image{img}, nil
+	}
+	last, err := sel.lastFrame(count)
+	if err != nil {
+		return nil, err
+	}
+
+	var canvas *image.RGBA
+	var frames []image.Image
+	errDone := errors.New("done")
+	i := 0
+	err = webpChunks(data, func(id string, payload []byte) error {
+		switch id {
+		case "VP8X":
+			if len(payload) < 10 {
+				return errors.New("webp: invalid VP8X chunk")
+			}
+			canvas = image.NewRGBA(image.Rect(0, 0, uint24(payload[4:])+1, uint24(payload[7:])+1))
+		case "ANMF":
+			if canvas == nil || len(payload) < 16 {
+				return errors.New("webp: invalid ANMF chunk")
+			}
+			x, y := 2*uint24(payload), 2*uint24(payload[3:])
+			w, h := uint24(payload[6:])+1, uint24(payload[9:])+1
+			flags := payload[15]
+			frame, err := webp.Decode(bytes.NewReader(standaloneWebP(w, h, payload[16:])))
+			if err != nil {
+				return err
+			}
+
+			r := image.Rect(x, y, x+w, y+h)
+			op := draw.Over
+			if flags&0x02 != 0 {
+				op = draw.Src
+			}
+			draw.Draw(canvas, r, frame, image.ZP, op)
+			if sel == FrameAll || i == last {
+				frames = append(frames, cloneRGBA(canvas))
+			}
+			if i == last {
+				return errDone
+			}
+			i++
+			if flags&0x01 != 0 {
+				draw.Draw(canvas, r, image.Transparent, image.ZP, draw.Src)
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errDone {
+		return nil, err
+	}
+	return frames, nil
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// standaloneWebP wraps the ALPH/VP8/VP8L chunks of one animation frame in a
+// RIFF container with a VP8X header of the frame's size.
+func standaloneWebP(width int, height int, chunks []byte) []byte {
+	vp8x := make([]byte, 18)
+	copy(vp8x, "VP8X")
+	binary.LittleEndian.PutUint32(vp8x[4:], 10)
+	if bytes.HasPrefix(chunks, []byte("ALPH")) {
+		vp8x[8] = 0x10
+	}
+	for i, v := range []int{width - 1, height - 1} {
+		vp8x[12+3*i], vp8x[13+3*i], vp8x[14+3*i] = byte(v), byte(v>>8), byte(v>>16)
+	}
+
+	out := make([]byte, 12, 12+len(vp8x)+len(chunks))
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(4+len(vp8x)+len(chunks)))
+	copy(out[8:], "WEBP")
+	return append(append(out, vp8x...), chunks...)
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	out := image.NewRGBA(img.Bounds())
+	copy(out.Pix, img.Pix)
+	return out
+}
+
+// decodeFrame decodes the frame sel selects, falling back to the
+// -frame option when the path did not name one.
+func (o Options) decodeFrame(data []byte, sel FrameSelection) (image.Image, error) {
+	if sel == "" {
+		sel = o.frame
+	}
+	// All resolves to the first frame for paths that were not expanded.
+	if sel == FrameAll {
+		sel = FrameFirst
+	}
+	frames, err := decodeFrames(data, sel)
+	if err != nil {
+		return nil, err
+	}
+	return frames[0], nil
+}
+
+// expandedFrames holds the frames expandFrames decoded, by their
+// "#frame=N" paths, until loadImage takes them.
+var expandedFrames = struct {
+	sync.Mutex
+	m map[string]image.Image
+}{m: make(map[string]image.Image)}
+
+// takeExpandedFrame returns and forgets the frame decoded for path.
+func takeExpandedFrame(path string) (image.Image, bool) {
+	expandedFrames.Lock()
+	defer expandedFrames.Unlock()
+	img, ok := expandedFrames.m[path]
+	delete(expandedFrames.m, path)
+	return img, ok
+}
+
+// expandFrames replaces each GIF or WebP argument with one "#frame=N" path
+// per frame when every frame was asked for, decoding each file once.
+func expandFrames(paths []string, sel FrameSelection) ([]string, error) {
+	if sel != FrameAll {
+		return paths, nil
+	}
+	var expanded []string
+	for _, path := range paths {
+		if _, frame := splitFramePath(path); frame != "" || !isAnimationPath(path) {
+			expanded = append(expanded, path)
+			continue
+		}
+		data, err := readInput(path)
+		if err != nil {
+			return nil, err
+		}
+		frames, err := decodeFrames(data, FrameAll)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		expandedFrames.Lock()
+		for i, frame := range frames {
+			framePath := fmt.Sprintf("%s#frame=%d", path, i+1)
+			expandedFrames.m[framePath] = frame
+			expanded = append(expanded, framePath)
+		}
+		expandedFrames.Unlock()
+	}
+	return expanded, nil
+}
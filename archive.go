@@ -0,0 +1,276 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func isZip(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("\x1f\x8b"))
+}
+
+func isTar(data []byte) bool {
+	return len(data) > 262 && string(data[257:262]) == "ustar"
+}
+
+func extractMember(dir string, n int, name string, r io.Reader) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%04d-%s", n, filepath.Base(name)))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// walkArchive calls visit with each regular file of a zip, tar or gzipped
+// tar archive in archive order. ok is false when data is not an archive.
+func walkArchive(data []byte, visit func(name string, modTime time.Time, r io.Reader) error) (ok bool, err error) {
+	if isZip(data) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return true, err
+		}
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return true, err
+			}
+			err = visit(zf.Name, zf.Modified, rc)
+			rc.Close()
+			if err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if isGzip(data) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return true, err
+		}
+		defer gz.Close()
+		if data, err = ioutil.ReadAll(gz); err != nil {
+			return true, err
+		}
+		if !isTar(data) {
+			return false, nil
+		}
+		r = bytes.NewReader(data)
+	} else if !isTar(data) {
+		return false, nil
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		if h.Typeflag == tar.TypeReg {
+			if err := visit(h.Name, h.ModTime, tr); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+func isArchivePath(path string) bool {
+	p := strings.ToLower(path)
+	return strings.HasSuffix(p, ".zip") || strings.HasSuffix(p, ".tar") || strings.HasSuffix(p, ".tar.gz") || strings.HasSuffix(p, ".tgz")
+}
+
+// archiveMember is an image read out of an archive argument.
+type archiveMember struct {
+	data    []byte
+	modTime time.Time
+}
+
+// archiveMembers holds the member images of archive arguments, keyed by
+// their "photos.zip#entry=trip/beach.jpg" paths.
+var archiveMembers = struct {
+	sync.Mutex
+	m map[string]archiveMember
+}{m: make(map[string]archiveMember)}
+
+// expandArchives replaces each zip, tar or gzipped tar argument with a
+// "#entry=" path per image in it, in archive order. The images stay in
+// memory. PDFs, videos and camera raw files are extracted into dir instead,
+// since the tools that decode them read files.
+func expandArchives(paths []string, dir string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		if !isArchivePath(path) {
+			expanded = append(expanded, path)
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		members, ok, err := readArchive(path, data, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: not a zip or tar archive", path)
+		}
+		expanded = append(expanded, members...)
+	}
+	return expanded, nil
+}
+
+// readArchive keeps the images of an archive named path in memory, as
+// "#entry=" members, and extracts the rest into dir. ok is false when data
+// is not an archive.
+func readArchive(path string, data []byte, dir string) (members []string, ok bool, err error) {
+	var local string
+	ok, err = walkArchive(data, func(name string, modTime time.Time, r io.Reader) error {
+		if !isInputFile(name) {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext == ".pdf" || videoExtensions[ext] || rawExtensions[ext] {
+			var err error
+			if local == "" {
+				if local, err = ioutil.TempDir(dir, "archive-"); err != nil {
+					return err
+				}
+			}
+			file, err := extractMember(local, len(members)+1, name, r)
+			if err == nil {
+				members = append(members, file)
+			}
+			return err
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		member := path + "#entry=" + name
+		archiveMembers.Lock()
+		_, seen := archiveMembers.m[member]
+		// A later member of the same name replaces the earlier one, as
+		// when tar extracts it.
+		archiveMembers.m[member] = archiveMember{data, modTime}
+		archiveMembers.Unlock()
+		if !seen {
+			members = append(members, member)
+		}
+		return nil
+	})
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if len(members) == 0 {
+		return nil, true, errors.New("archive holds no images")
+	}
+	return members, true, nil
+}
+
+// readInput reads an input file or archive member.
+func readInput(path string) ([]byte, error) {
+	archiveMembers.Lock()
+	m, ok := archiveMembers.m[path]
+	archiveMembers.Unlock()
+	if ok {
+		return m.data, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// openInput opens an input file or archive member for reading.
+func openInput(path string) (io.ReadCloser, error) {
+	archiveMembers.Lock()
+	m, ok := archiveMembers.m[path]
+	archiveMembers.Unlock()
+	if ok {
+		return ioutil.NopCloser(bytes.NewReader(m.data)), nil
+	}
+	return os.Open(path)
+}
+
+// inputModTime is the modification time of an input file or archive member.
+func inputModTime(path string) (time.Time, error) {
+	archiveMembers.Lock()
+	m, ok := archiveMembers.m[path]
+	archiveMembers.Unlock()
+	if ok {
+		return m.modTime, nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// readStdin replaces a "-" argument with the images of the zip or tar
+// stream on stdin, as "-#entry=" members, or with a single "-" image when
+// stdin is not an archive. Either way the images stay in memory.
+func readStdin(paths []string, dir string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		if path != "-" {
+			expanded = append(expanded, path)
+			continue
+		}
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		members, ok, err := readArchive(path, data, dir)
+		if err != nil {
+			return nil, fmt.Errorf("stdin: %v", err)
+		}
+		if !ok {
+			archiveMembers.Lock()
+			archiveMembers.m[path] = archiveMember{data, time.Now()}
+			archiveMembers.Unlock()
+			members = []string{path}
+		}
+		expanded = append(expanded, members...)
+	}
+	return expanded, nil
+}
+
+// readPathList reads one path per line, as printed by find or ls, skipping
+// blank lines.
+func readPathList(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
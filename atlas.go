@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Sprite is one image placed in a texture atlas.
+type Sprite struct {
+	name string
+	img  image.Image
+	rect image.Rectangle
+}
+
+// nextPowerOfTwo is the smallest power of two not below n.
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// shelfPack places sprites, tallest first, left to right on shelves of the
+// given width, with padding pixels between neighbors. It returns the height
+// used, or false when a sprite is wider than the shelves.
+func shelfPack(sprites []Sprite, width int, padding int) (int, bool) {
+	x, y, shelf := 0, 0, 0
+	for i := range sprites {
+		w, h := Width(sprites[i].img), Height(sprites[i].img)
+		if w > width {
+			return 0, false
+		}
+		if x > 0 && x+w > width {
+			x, y, shelf = 0, y+shelf+padding, 0
+		}
+		sprites[i].rect = image.Rect(x, y, x+w, y+h)
+		x += w + padding
+		if h > shelf {
+			shelf = h
+		}
+	}
+	return y + shelf, true
+}
+
+// packAtlas sorts sprites tallest first and places them in the smallest
+// atlas, by area and then by its longer side, that holds them within
+// maxSize. With pot both sides are powers of two; otherwise the atlas is
+// trimmed to the sprites.
+func packAtlas(sprites []Sprite, padding int, pot bool, maxSize int) (image.Point, error) {
+	sort.SliceStable(sprites, func(i, j int) bool {
+		return Height(sprites[i].img) > Height(sprites[j].img)
+	})
+
+	var best image.Point
+	var bestRects []image.Rectangle
+	for width := 1; width <= maxSize; width *= 2 {
+		height, ok := shelfPack(sprites, width, padding)
+		if !ok {
+			continue
+		}
+		size := image.Pt(width, height)
+		if pot {
+			size.Y = nextPowerOfTwo(height)
+		} else {
+			size.X = 0
+			for _, s := range sprites {
+				if s.rect.Max.X > size.X {
+					size.X = s.rect.Max.X
+				}
+			}
+		}
+		if size.Y > maxSize {
+			continue
+		}
+		if bestRects == nil || size.X*size.Y < best.X*best.Y ||
+			(size.X*size.Y == best.X*best.Y && math.Max(float64(size.X), float64(size.Y)) < math.Max(float64(best.X), float64(best.Y))) {
+			best = size
+			bestRects = make([]image.Rectangle, len(sprites))
+			for i, s := range sprites {
+				bestRects[i] = s.rect
+			}
+		}
+	}
+	if bestRects == nil {
+		return image.Point{}, fmt.Errorf("atlas: sprites do not fit in %dx%d", maxSize, maxSize)
+	}
+	for i := range sprites {
+		sprites[i].rect = bestRects[i]
+	}
+	return best, nil
+}
+
+// makeAtlas draws the packed sprites unscaled onto a transparent canvas.
+func makeAtlas(size image.Point, sprites []Sprite) *MyImage {
+	opts := Options{}
+	for _, s := range sprites {
+		opts.deep = opts.deep || isDeep(s.img)
+	}
+	atlas := opts.newCanvas(image.Rectangle{Max: size})
+	for _, s := range sprites {
+		draw.Draw(atlas.value, s.rect, s.img, s.img.Bounds().Min, draw.Src)
+	}
+	return &atlas
+}
+
+type atlasRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type atlasSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type atlasFrame struct {
+	Frame atlasRect `json:"frame"`
+}
+
+type atlasMeta struct {
+	Image string    `json:"image"`
+	Size  atlasSize `json:"size"`
+	Scale float64   `json:"scale"`
+}
+
+// atlasJSON describes the sprites in the JSON hash format of TexturePacker,
+// which Phaser, PixiJS and most engines read.
+func atlasJSON(imagePath string, size image.Point, scale float64, sprites []Sprite) ([]byte, error) {
+	frames := make(map[string]atlasFrame, len(sprites))
+	for _, s := range sprites {
+		frames[s.name] = atlasFrame{atlasRect{s.rect.Min.X, s.rect.Min.Y, s.rect.Dx(), s.rect.Dy()}}
+	}
+	return json.MarshalIndent(struct {
+		Frames map[string]atlasFrame `json:"frames"`
+		Meta   atlasMeta             `json:"meta"`
+	}{frames, atlasMeta{imagePath, atlasSize{size.X, size.Y}, scale}}, "", "  ")
+}
+
+var cssClassReplacer = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// atlasCSS writes a .sprite class with the atlas as background and one
+// .sprite-<name> class per sprite that sizes and offsets it.
+func atlasCSS(imagePath string, sprites []Sprite) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, ".sprite {\n  background-image: url(%q);\n  background-repeat: no-repeat;\n}\n", imagePath)
+	for _, s := range sprites {
+		fmt.Fprintf(&b, "\n.sprite-%s {\n  width: %dpx;\n  height: %dpx;\n  background-position: %dpx %dpx;\n}\n",
+			cssClassReplacer.ReplaceAllString(s.name, "-"), s.rect.Dx(), s.rect.Dy(), -s.rect.Min.X, -s.rect.Min.Y)
+	}
+	return b.Bytes()
+}
+
+func atlasMain(args []string) {
+	fs := flag.NewFlagSet("atlas", flag.ExitOnError)
+	configureLogging := addLogFlags(fs)
+	outputPath := fs.String("o", "atlas.png", "atlas image file")
+	jsonPath := fs.String("json", "", "sprite rects as TexturePacker JSON (default: the -o path with a .json extension)")
+	cssPath := fs.String("css", "", "also write a stylesheet with a .sprite-<name> class per sprite")
+	scale := fs.Float64("scale", 1, "scale every sprite by this factor")
+	padding := fs.Int("padding", 1, "transparent pixels between sprites")
+	pot := fs.Bool("pot", true, "make both atlas sides powers of two")
+	maxSize := fs.Int("max-size", 4096, "largest atlas side in pixels")
+	raw := fs.String("raw", "auto", "camera raw handling: decode (dcraw/libraw), preview (embedded JPEG) or auto")
+	fs.Parse(args)
+	configureLogging()
+
+	if fs.NArg() == 0 {
+		logger.Fatal("Usage: imagecollager atlas [flags] <image>...")
+	}
+	if *scale <= 0 || *padding < 0 || *maxSize < 1 {
+		logger.Fatal("Scale and max size must be positive and padding must not be negative")
+	}
+	if *jsonPath == "" {
+		*jsonPath = strings.TrimSuffix(*outputPath, filepath.Ext(*outputPath)) + ".json"
+	}
+
+	images := loadImages(Options{raw: RawMode(*raw)}, fs.Args())
+	sprites := make([]Sprite, len(images))
+	names := make(map[string]string)
+	for i, img := range images {
+		path := fs.Arg(i)
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if other, ok := names[name]; ok {
+			logger.Fatalf("Sprites %s and %s are both named %q", other, path, name)
+		}
+		names[name] = path
+		if *scale != 1 {
+			img = resample(img, uint(math.Max(1, math.Round(float64(Width(img))**scale))), uint(math.Max(1, math.Round(float64(Height(img))**scale))))
+		}
+		sprites[i] = Sprite{name: name, img: img}
+	}
+
+	size, err := packAtlas(sprites, *padding, *pot, *maxSize)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := saveImage(*outputPath, makeAtlas(size, sprites).value, Encoding{}); err != nil {
+		logger.Fatal(err)
+	}
+	imagePath := filepath.Base(*outputPath)
+	data, err := atlasJSON(imagePath, size, *scale, sprites)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*jsonPath, append(data, '\n'), 0644); err != nil {
+		logger.Fatal(err)
+	}
+	if *cssPath != "" {
+		if err := ioutil.WriteFile(*cssPath, atlasCSS(imagePath, sprites), 0644); err != nil {
+			logger.Fatal(err)
+		}
+	}
+}
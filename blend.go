@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// BlendMode mixes a tile's colors with what is already on the canvas
+// where tiles overlap, as in the W3C compositing modes of the same names.
+type BlendMode string
+
+const (
+	BlendNormal   BlendMode = "normal"
+	BlendMultiply BlendMode = "multiply"
+	BlendScreen   BlendMode = "screen"
+	BlendLighten  BlendMode = "lighten"
+	BlendDarken   BlendMode = "darken"
+)
+
+func parseBlendMode(s string) (BlendMode, error) {
+	switch m := BlendMode(s); m {
+	case "":
+		return BlendNormal, nil
+	case BlendNormal, BlendMultiply, BlendScreen, BlendLighten, BlendDarken:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown blend mode %q; use normal, multiply, screen, lighten or darken", s)
+}
+
+func (m BlendMode) blend(cb float64, cs float64) float64 {
+	switch m {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendLighten:
+		return math.Max(cb, cs)
+	case BlendDarken:
+		return math.Min(cb, cs)
+	}
+	return cs
+}
+
+// tileBlend is the blend mode and opacity of a tile: its style's, else the
+// global ones. A zero opacity means opaque, as in the zero Options.
+func (o Options) tileBlend(img image.Image) (BlendMode, float64) {
+	mode, opacity := o.blend, o.opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+	if s, ok := o.styles[img]; ok {
+		if s.Blend != "" {
+			mode = s.Blend
+		}
+		if s.Opacity != nil {
+			opacity = *s.Opacity
+		}
+	}
+	if mode == "" {
+		mode = BlendNormal
+	}
+	return mode, opacity
+}
+
+// blendDraw composites src, through mask when not nil, onto dst within r
+// like draw.DrawMask with draw.Over, but mixing colors with mode and
+// scaling the source alpha by opacity.
+func blendDraw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, mask image.Image, mp image.Point, mode BlendMode, opacity float64) {
+	if mode == BlendNormal && opacity >= 1 {
+		// Draw on the canvas itself so image/draw takes its fast paths.
+		if m, ok := dst.(*MyImage); ok {
+			dst = m.value
+		}
+		if mask == nil {
+			draw.Draw(dst, r, src, sp, draw.Over)
+		} else {
+			draw.DrawMask(dst, r, src, sp, mask, mp, draw.Over)
+		}
+		return
+	}
+
+	// Offsets into src and mask from dst coordinates.
+	so, mo := sp.Sub(r.Min), mp.Sub(r.Min)
+	r = r.Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			s := color.RGBA64Model.Convert(src.At(x+so.X, y+so.Y)).(color.RGBA64)
+			as := float64(s.A) / 0xffff * opacity
+			if mask != nil {
+				_, _, _, ma := mask.At(x+mo.X, y+mo.Y).RGBA()
+				as *= float64(ma) / 0xffff
+			}
+			if as <= 0 || s.A == 0 {
+				continue
+			}
+			d := color.RGBA64Model.Convert(dst.At(x, y)).(color.RGBA64)
+			ab := float64(d.A) / 0xffff
+
+			// Straight source and backdrop colors, then source-over of the
+			// blended color: co = as*(1-ab)*cs + as*ab*B(cb, cs) + (1-as)*ab*cb.
+			mix := func(sc uint16, dc uint16) uint16 {
+				cs := float64(sc) / float64(s.A)
+				cb := 0.0
+				if d.A > 0 {
+					cb = float64(dc) / float64(d.A)
+				}
+				co := as*(1-ab)*cs + as*ab*mode.blend(cb, cs) + (1-as)*ab*cb
+				return uint16(math.Round(clamp01(co) * 0xffff))
+			}
+			dst.Set(x, y, color.RGBA64{
+				mix(s.R, d.R), mix(s.G, d.G), mix(s.B, d.B),
+				uint16(math.Round(clamp01(as+ab*(1-as)) * 0xffff)),
+			})
+		}
+	}
+}
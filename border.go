@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Border is drawn around the finished collage: a frame of width pixels in
+// color, then corners rounded to radius, transparent outside.
+type Border struct {
+	width  int
+	color  color.Color
+	radius int
+}
+
+// RoundedRect is a mask that is opaque inside a rectangle with rounded
+// corners, with a one pixel antialiased edge.
+type RoundedRect struct {
+	r      image.Rectangle
+	radius int
+}
+
+func (m *RoundedRect) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (m *RoundedRect) Bounds() image.Rectangle {
+	return m.r
+}
+
+func (m *RoundedRect) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(m.r) {
+		return color.Alpha{0}
+	}
+	// Distance from the pixel center to the nearest corner circle's
+	// center, on the axes where the pixel lies beyond it.
+	rr := float64(m.radius)
+	px, py := float64(x)+0.5, float64(y)+0.5
+	dx := math.Max(math.Max(float64(m.r.Min.X)+rr-px, px-(float64(m.r.Max.X)-rr)), 0)
+	dy := math.Max(math.Max(float64(m.r.Min.Y)+rr-py, py-(float64(m.r.Max.Y)-rr)), 0)
+	coverage := clamp01(rr - math.Hypot(dx, dy) + 0.5)
+	return color.Alpha{uint8(255 * coverage)}
+}
+
+// borderPage frames page and rounds its corners. Without a border the page
+// is returned as is.
+func (o Options) borderPage(page *MyImage) *MyImage {
+	f := o.border
+	if f.width > 0 {
+		b := page.value.Bounds()
+		framed := o.newCanvas(image.Rect(0, 0, b.Dx()+2*f.width, b.Dy()+2*f.width))
+		draw.Draw(framed.value, framed.value.Bounds(), image.NewUniform(f.color), image.ZP, draw.Src)
+		draw.Draw(framed.value, b.Sub(b.Min).Add(image.Pt(f.width, f.width)), page.value, b.Min, draw.Over)
+		page = &framed
+	}
+	if f.radius > 0 {
+		b := page.value.Bounds()
+		radius := f.radius
+		if max := int(math.Min(float64(b.Dx()), float64(b.Dy())) / 2); radius > max {
+			radius = max
+		}
+		rounded := o.newCanvas(b)
+		draw.DrawMask(rounded.value, b, page.value, b.Min, &RoundedRect{b, radius}, b.Min, draw.Src)
+		page = &rounded
+	}
+	return page
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type DatedImage struct {
+	img  image.Image
+	date time.Time
+}
+
+// earliestDate picks the default calendar month. It depends on the photos
+// only, not on the clock, so a rerun renders the same month.
+func earliestDate(photos []DatedImage) time.Time {
+	if len(photos) == 0 {
+		return time.Time{}
+	}
+	earliest := photos[0].date
+	for _, p := range photos[1:] {
+		if p.date.Before(earliest) {
+			earliest = p.date
+		}
+	}
+	return earliest
+}
+
+// makeCalendarCollage lays photos out as a Monday-first month calendar, one
+// cell per day. Days with several photos show the earliest one.
+func makeCalendarCollage(opts Options, month time.Time, photos []DatedImage) *MyImage {
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	days := first.AddDate(0, 1, -1).Day()
+	offset := (int(first.Weekday()) + 6) % 7
+	weeks := (offset + days + 6) / 7
+
+	sort.SliceStable(photos, func(i, j int) bool {
+		return photos[i].date.Before(photos[j].date)
+	})
+	byDay := make(map[int]image.Image)
+	for _, p := range photos {
+		if p.date.Year() != first.Year() || p.date.Month() != first.Month() {
+			continue
+		}
+		if _, ok := byDay[p.date.Day()]; !ok {
+			byDay[p.date.Day()] = p.img
+		}
+	}
+
+	padding := 1
+	cell := (opts.width - 8*padding) / 7
+	titleHeight := labelHeight + 8
+	headerHeight := labelHeight + 4
+	top := titleHeight + headerHeight
+
+	rectangleEnd := image.Point{7*cell + 8*padding, top + weeks*(cell+padding) + padding}
+	output := opts.newCanvas(image.Rectangle{image.ZP, rectangleEnd})
+
+	title := first.Format("January 2006")
+	output.drawString(title, (rectangleEnd.X-textWidth(title))/2, 4+labelFace.Ascent, color.White)
+	for col := 0; col < 7; col++ {
+		name := time.Weekday((col + 1) % 7).String()[:3]
+		x := padding + col*(cell+padding) + (cell-textWidth(name))/2
+		output.drawString(name, x, titleHeight+labelFace.Ascent, color.White)
+	}
+
+	backdrop := image.NewUniform(color.NRGBA{0, 0, 0, 160})
+	for day := 1; day <= days; day++ {
+		idx := offset + day - 1
+		sp := image.Point{padding + (idx%7)*(cell+padding), top + padding + (idx/7)*(cell+padding)}
+
+		if img, ok := byDay[day]; ok {
+			output.drawRaw(coverTile(img, cell, cell), sp)
+		} else if opts.emptyColor != nil {
+			draw.Draw(&output, image.Rectangle{sp, sp.Add(image.Point{cell, cell})}, image.NewUniform(opts.emptyColor), image.ZP, draw.Src)
+		}
+
+		label := strconv.Itoa(day)
+		draw.Draw(&output, image.Rect(sp.X, sp.Y, sp.X+textWidth(label)+6, sp.Y+labelHeight+4), backdrop, image.ZP, draw.Over)
+		output.drawString(label, sp.X+3, sp.Y+2+labelFace.Ascent, color.White)
+	}
+
+	return &output
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+const captionGap = 4
+
+type CaptionAlign string
+
+const (
+	AlignLeft   CaptionAlign = "left"
+	AlignCenter CaptionAlign = "center"
+	AlignRight  CaptionAlign = "right"
+)
+
+// captionFace returns the bitmap label font for "basic" and the Go fonts at
+// the given point size for "regular" and "mono".
+func captionFace(name string, size float64) (font.Face, error) {
+	var ttf []byte
+	switch name {
+	case "basic":
+		return labelFace, nil
+	case "regular":
+		ttf = goregular.TTF
+	case "mono":
+		ttf = gomono.TTF
+	default:
+		return nil, fmt.Errorf("unknown caption font %q", name)
+	}
+
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+func formatShutter(t float64) string {
+	if t >= 1 {
+		return fmt.Sprintf("%gs", math.Round(t*10)/10)
+	}
+	return fmt.Sprintf("1/%.0fs", math.Round(1/t))
+}
+
+// exifCaption formats the photo-club tech line pair: camera and lens on the
+// first line, focal length, aperture, shutter and ISO on the second.
+func exifCaption(path string) []string {
+	e, err := readExifFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var gear, exposure []string
+	maker, _ := e.String(exifTagMake)
+	if model, ok := e.String(exifTagModel); ok {
+		if maker != "" && !strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+			model = maker + " " + model
+		}
+		gear = append(gear, model)
+	} else if maker != "" {
+		gear = append(gear, maker)
+	}
+	if lens, ok := e.String(exifTagLensModel); ok && lens != "" {
+		gear = append(gear, lens)
+	}
+
+	if f, ok := e.Rational(exifTagFocalLength); ok {
+		exposure = append(exposure, fmt.Sprintf("%gmm", math.Round(f)))
+	}
+	if f, ok := e.Rational(exifTagFNumber); ok {
+		exposure = append(exposure, fmt.Sprintf("f/%g", math.Round(f*10)/10))
+	}
+	if t, ok := e.Rational(exifTagExposureTime); ok && t > 0 {
+		exposure = append(exposure, formatShutter(t))
+	}
+	if iso, ok := e.Int(exifTagISO); ok {
+		exposure = append(exposure, fmt.Sprintf("ISO %d", iso))
+	}
+
+	return []string{strings.Join(gear, " | "), strings.Join(exposure, "  ")}
+}
+
+func (o Options) captionLines() int {
+	lines := 0
+	for _, caption := range o.captions {
+		if len(caption) > lines {
+			lines = len(caption)
+		}
+	}
+	return lines
+}
+
+func (o Options) captionHeight() int {
+	lines := o.captionLines()
+	if lines == 0 {
+		return 0
+	}
+	return captionGap + lines*o.captionFace.Metrics().Height.Ceil()
+}
+
+func (bgImg *MyImage) drawCaption(opts Options, lines []string, sp image.Point, width int) {
+	face := opts.captionFace
+	lineHeight := face.Metrics().Height.Ceil()
+	baseline := sp.Y + captionGap + face.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		x := sp.X
+		switch opts.captionAlign {
+		case AlignCenter:
+			x += (width - font.MeasureString(face, line).Round()) / 2
+		case AlignRight:
+			x += width - font.MeasureString(face, line).Round()
+		}
+		bgImg.drawText(face, line, x, baseline, color.White)
+		baseline += lineHeight
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// packCircles places circles of the given radii without overlaps, largest
+// first, by front-chain packing: the placed circles are ringed by a chain
+// of the outermost ones, and each new circle goes tangent to the pair of
+// neighbors on the chain closest to the origin, measured relative to a
+// width x height canvas so the pack grows to its shape. When the new
+// circle would overlap another circle of the chain, the chain is cut short
+// to that circle and the circle is placed again. It returns the centers.
+func packCircles(radii []float64, width float64, height float64) [][2]float64 {
+	order := make([]int, len(radii))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return radii[order[i]] > radii[order[j]] })
+
+	centers := make([][2]float64, len(radii))
+	if len(order) == 2 {
+		a, b := order[0], order[1]
+		centers[a], centers[b] = [2]float64{-radii[b], 0}, [2]float64{radii[a], 0}
+	}
+	if len(order) < 3 {
+		return centers
+	}
+
+	// place puts c tangent to a and b, on the left of the way from a to b.
+	place := func(a, b, c int) {
+		pa, pb := centers[a], centers[b]
+		dx, dy := pb[0]-pa[0], pb[1]-pa[1]
+		d2 := dx*dx + dy*dy
+		if d2 == 0 {
+			centers[c] = [2]float64{pa[0] + radii[c], pa[1]}
+			return
+		}
+		a2, b2 := (radii[a]+radii[c])*(radii[a]+radii[c]), (radii[b]+radii[c])*(radii[b]+radii[c])
+		if a2 > b2 {
+			x := (d2 + b2 - a2) / (2 * d2)
+			y := math.Sqrt(math.Max(0, b2/d2-x*x))
+			centers[c] = [2]float64{pb[0] - x*dx - y*dy, pb[1] - x*dy + y*dx}
+		} else {
+			x := (d2 + a2 - b2) / (2 * d2)
+			y := math.Sqrt(math.Max(0, a2/d2-x*x))
+			centers[c] = [2]float64{pa[0] + x*dx - y*dy, pa[1] + x*dy + y*dx}
+		}
+	}
+	intersects := func(a, b int) bool {
+		dr := radii[a] + radii[b] - 1e-9*(radii[a]+radii[b])
+		dx, dy := centers[b][0]-centers[a][0], centers[b][1]-centers[a][1]
+		return dr > 0 && dr*dr > dx*dx+dy*dy
+	}
+
+	// next and prev link the chain, by circle index.
+	next := make([]int, len(radii))
+	prev := make([]int, len(radii))
+	// score is how far from the origin, relative to the canvas, a circle
+	// put between a and its successor would come.
+	score := func(a int) float64 {
+		b := next[a]
+		ab := radii[a] + radii[b]
+		x := (centers[a][0]*radii[b] + centers[b][0]*radii[a]) / ab
+		y := (centers[a][1]*radii[b] + centers[b][1]*radii[a]) / ab
+		return x*x/(width*width) + y*y/(height*height)
+	}
+
+	a, b, c := order[0], order[1], order[2]
+	centers[a], centers[b] = [2]float64{-radii[b], 0}, [2]float64{radii[a], 0}
+	place(b, a, c)
+	next[a], prev[b] = b, a
+	next[b], prev[c] = c, b
+	next[c], prev[a] = a, c
+
+	for i := 3; i < len(order); i++ {
+		c := order[i]
+		place(a, b, c)
+
+		// Look for the closest circle on the chain the new one overlaps,
+		// going both ways from a and b, closeness measured along the chain.
+		j, k, sj, sk := next[b], prev[a], radii[b], radii[a]
+		overlapped := false
+		for {
+			if sj <= sk {
+				if intersects(j, c) {
+					b = j
+					next[a], prev[b] = b, a
+					overlapped = true
+					break
+				}
+				sj += radii[j]
+				j = next[j]
+			} else {
+				if intersects(k, c) {
+					a = k
+					next[a], prev[b] = b, a
+					overlapped = true
+					break
+				}
+				sk += radii[k]
+				k = prev[k]
+			}
+			if j == next[k] {
+				break
+			}
+		}
+		if overlapped {
+			i--
+			continue
+		}
+
+		next[a], prev[c], next[c], prev[b] = c, a, b, c
+		b = c
+		// The next circle goes by the pair closest to the origin.
+		best := score(a)
+		for n := next[c]; n != b; n = next[n] {
+			if s := score(n); s < best {
+				a, best = n, s
+			}
+		}
+		b = next[a]
+	}
+	return centers
+}
+
+// makeCirclePack draws each image in a circle with an area in proportion
+// to its weight in opts.weights or, when no image has one, to its pixel
+// count, packed tightly and scaled to fill the opts.width x opts.height
+// canvas, with gap pixels between neighbors.
+func makeCirclePack(opts Options, images []image.Image, gap float64) *MyImage {
+	radii := make([]float64, len(images))
+	for i, img := range images {
+		area := float64(Width(img)) * float64(Height(img))
+		if len(opts.weights) > 0 {
+			area = 1
+			if w, ok := opts.weights[img]; ok {
+				area = w
+			}
+		}
+		radii[i] = math.Sqrt(area)
+	}
+	w, h := float64(opts.width), float64(opts.height)
+	centers := packCircles(radii, w, h)
+
+	lo, hi := [2]float64{math.Inf(1), math.Inf(1)}, [2]float64{math.Inf(-1), math.Inf(-1)}
+	for i, c := range centers {
+		lo = [2]float64{math.Min(lo[0], c[0]-radii[i]), math.Min(lo[1], c[1]-radii[i])}
+		hi = [2]float64{math.Max(hi[0], c[0]+radii[i]), math.Max(hi[1], c[1]+radii[i])}
+	}
+	scale := math.Min(w/(hi[0]-lo[0]), h/(hi[1]-lo[1]))
+	offset := [2]float64{(w - (hi[0]-lo[0])*scale) / 2, (h - (hi[1]-lo[1])*scale) / 2}
+
+	output := opts.newCanvas(image.Rect(0, 0, opts.width, opts.height))
+	tiles := make([]image.Image, len(images))
+	spots := make([]image.Point, len(images))
+	parallel(len(images), largestDecoded(images), func(i int) {
+		d := int(math.Floor(2*radii[i]*scale - gap))
+		if d < 1 {
+			return
+		}
+		x := offset[0] + (centers[i][0]-lo[0])*scale
+		y := offset[1] + (centers[i][1]-lo[1])*scale
+		spots[i] = image.Pt(int(math.Round(x-float64(d)/2)), int(math.Round(y-float64(d)/2)))
+		tiles[i] = opts.vignetteTile(coverTile(images[i], d, d), CircleShape)
+	})
+	for i, tile := range tiles {
+		if tile != nil {
+			mode, opacity := opts.tileBlend(images[i])
+			output.drawInCircle(tile, spots[i], Width(tile), mode, opacity)
+		}
+	}
+	return &output
+}
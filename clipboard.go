@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// copyToClipboard places img on the system clipboard as a PNG. macOS and
+// Windows read it from a temporary file; on Linux and the BSDs it is piped
+// to wl-copy under Wayland, or to xclip.
+func copyToClipboard(img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		f, err := ioutil.TempFile("", "imagecollager-*.png")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if runtime.GOOS == "darwin" {
+			return runClipboard(nil, "osascript", "-e", `set the clipboard to (read (POSIX file "`+f.Name()+`") as «class PNGf»)`)
+		}
+		return runClipboard(nil, "powershell", "-NoProfile", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms,System.Drawing; "+
+				"[System.Windows.Forms.Clipboard]::SetImage([System.Drawing.Image]::FromFile('"+f.Name()+"'))")
+	}
+
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-copy"); err == nil {
+			return runClipboard(&buf, "wl-copy", "--type", "image/png")
+		}
+	}
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return runClipboard(&buf, "xclip", "-selection", "clipboard", "-t", "image/png", "-i")
+	}
+	return errors.New("clipboard: install wl-copy (Wayland) or xclip (X11)")
+}
+
+func runClipboard(stdin *bytes.Buffer, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New("clipboard: " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	return nil
+}
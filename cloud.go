@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// imageExtensions are the still image suffixes kept when a whole bucket
+// prefix is fetched. Raw, PDF and video inputs are added on top.
+var imageExtensions = map[string]bool{
+	".fit": true, ".fits": true, ".gif": true, ".jpeg": true, ".jpg": true,
+	".pdf": true, ".png": true, ".svg": true, ".tif": true, ".tiff": true, ".webp": true,
+}
+
+func isInputFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return imageExtensions[ext] || rawExtensions[ext] || videoExtensions[ext]
+}
+
+// isRemote reports whether path is an s3://, gs:// or az:// object or
+// prefix. Azure URIs name the storage account first:
+// az://account/container/path.
+func isRemote(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://") || strings.HasPrefix(path, "az://")
+}
+
+func runCloudCLI(name string, args ...string) error {
+	p, err := exec.LookPath(name)
+	if err != nil {
+		return errors.New("cloud: " + name + " CLI not found in PATH")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(p, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New("cloud: " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	return nil
+}
+
+// azureParts splits az://account/container/name.
+func azureParts(uri string) (account string, container string, name string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(uri, "az://"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("cloud: expected az://account/container/path, got %q", uri)
+	}
+	if len(parts) == 3 {
+		name = parts[2]
+	}
+	return parts[0], parts[1], name, nil
+}
+
+// copyFromCloud downloads an object to the file dst, or with recursive a
+// whole prefix into the directory dst. The provider CLIs pick up
+// credentials from their standard chains: environment, config files and
+// instance metadata. az falls back to a login session when no storage key
+// or connection string is set.
+func copyFromCloud(uri string, dst string, recursive bool) error {
+	switch uri[:2] {
+	case "s3":
+		if recursive {
+			return runCloudCLI("aws", "s3", "cp", "--recursive", "--only-show-errors", uri, dst)
+		}
+		return runCloudCLI("aws", "s3", "cp", "--only-show-errors", uri, dst)
+	case "gs":
+		if recursive {
+			return runCloudCLI("gcloud", "storage", "cp", "--recursive", "--no-user-output-enabled", uri, dst)
+		}
+		return runCloudCLI("gcloud", "storage", "cp", "--no-user-output-enabled", uri, dst)
+	}
+	account, container, name, err := azureParts(uri)
+	if err != nil {
+		return err
+	}
+	if recursive {
+		return runCloudCLI("az", "storage", "blob", "download-batch", "--only-show-errors",
+			"--account-name", account, "--source", container, "--pattern", name+"*", "--destination", dst)
+	}
+	return runCloudCLI("az", "storage", "blob", "download", "--only-show-errors",
+		"--account-name", account, "--container-name", container, "--name", name, "--file", dst)
+}
+
+func copyToCloud(src string, uri string) error {
+	switch uri[:2] {
+	case "s3":
+		return runCloudCLI("aws", "s3", "cp", "--only-show-errors", src, uri)
+	case "gs":
+		return runCloudCLI("gcloud", "storage", "cp", "--no-user-output-enabled", src, uri)
+	}
+	account, container, name, err := azureParts(uri)
+	if err != nil {
+		return err
+	}
+	return runCloudCLI("az", "storage", "blob", "upload", "--only-show-errors", "--overwrite",
+		"--account-name", account, "--container-name", container, "--name", name, "--file", src)
+}
+
+// fetchRemote downloads every remote argument into a fresh directory under
+// dir and replaces it with the local files. A URI naming an image is one
+// object; anything else is a prefix whose images are all fetched, in name
+// order.
+func fetchRemote(paths []string, dir string) ([]string, error) {
+	var fetched []string
+	for _, uri := range paths {
+		if !isRemote(uri) {
+			fetched = append(fetched, uri)
+			continue
+		}
+		local, err := ioutil.TempDir(dir, "remote-")
+		if err != nil {
+			return nil, err
+		}
+
+		if isInputFile(path.Base(uri)) {
+			file := filepath.Join(local, path.Base(uri))
+			if err := copyFromCloud(uri, file, false); err != nil {
+				return nil, fmt.Errorf("%s: %v", uri, err)
+			}
+			fetched = append(fetched, file)
+			continue
+		}
+
+		if err := copyFromCloud(uri, local, true); err != nil {
+			return nil, fmt.Errorf("%s: %v", uri, err)
+		}
+		n := len(fetched)
+		err = filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && isInputFile(p) {
+				fetched = append(fetched, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(fetched) == n {
+			return nil, fmt.Errorf("%s: no images found", uri)
+		}
+	}
+	return fetched, nil
+}
+
+// uploadImage encodes img to a temporary file named like the object, so the
+// extension picks the format, and copies it to uri.
+func uploadImage(uri string, img image.Image, enc Encoding) error {
+	dir, err := ioutil.TempDir("", "imagecollager-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, path.Base(uri))
+	if err := saveImage(local, img, enc); err != nil {
+		return err
+	}
+	return copyToCloud(local, uri)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const dividerWidth = 3
+
+type LabeledImage struct {
+	label string
+	img   image.Image
+}
+
+// comparisonRank puts "before" first and "after" last so that suffix-paired
+// rows read left to right; any other variants sit in between.
+func comparisonRank(variant string) int {
+	switch strings.ToLower(variant) {
+	case "before":
+		return 0
+	case "after":
+		return 2
+	}
+	return 1
+}
+
+func pairBySuffix(paths []string, images []image.Image, sep string) [][]LabeledImage {
+	groups, variants := groupVariants(paths, images, sep)
+	sort.SliceStable(variants, func(i, j int) bool {
+		return comparisonRank(variants[i]) < comparisonRank(variants[j])
+	})
+
+	rows := make([][]LabeledImage, 0, len(groups))
+	for _, g := range groups {
+		var row []LabeledImage
+		for _, v := range variants {
+			if img, ok := g.variants[v]; ok {
+				row = append(row, LabeledImage{v, img})
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func pairByOrder(paths []string, images []image.Image, n int) [][]LabeledImage {
+	var rows [][]LabeledImage
+	for i, img := range images {
+		if i%n == 0 {
+			rows = append(rows, nil)
+		}
+		label := strings.TrimSuffix(filepath.Base(paths[i]), filepath.Ext(paths[i]))
+		rows[len(rows)-1] = append(rows[len(rows)-1], LabeledImage{label, img})
+	}
+	return rows
+}
+
+// makeComparisonSheet renders each group as one row whose images share the
+// same height, chosen so the row spans the canvas width.
+func makeComparisonSheet(opts Options, rows [][]LabeledImage, divider bool, labels bool) *MyImage {
+	gap := 1
+	if divider {
+		gap = dividerWidth
+	}
+
+	heights := make([]int, len(rows))
+	totalHeight := gap
+	for r, row := range rows {
+		aspect := 0.0
+		for _, li := range row {
+			aspect += float64(Width(li.img)) / float64(Height(li.img))
+		}
+		heights[r] = int(float64(opts.width-(len(row)+1)*gap) / aspect)
+		totalHeight += heights[r] + gap
+	}
+
+	output := opts.newCanvas(image.Rect(0, 0, opts.width, totalHeight))
+	backdrop := image.NewUniform(color.NRGBA{0, 0, 0, 160})
+
+	sp_y := gap
+	for r, row := range rows {
+		sp_x := gap
+		for i, li := range row {
+			tile := resizeHeight(li.img, heights[r])
+			output.drawRaw(tile, image.Point{sp_x, sp_y})
+
+			if labels {
+				box := image.Rect(sp_x, sp_y, sp_x+textWidth(li.label)+8, sp_y+labelHeight+6)
+				draw.Draw(&output, box, backdrop, image.ZP, draw.Over)
+				output.drawString(li.label, sp_x+4, sp_y+3+labelFace.Ascent, color.White)
+			}
+
+			sp_x += Width(tile) + gap
+			if divider && i < len(row)-1 {
+				draw.Draw(&output, image.Rect(sp_x-gap, sp_y, sp_x, sp_y+heights[r]), image.White, image.ZP, draw.Src)
+			}
+		}
+		sp_y += heights[r] + gap
+	}
+
+	return &output
+}
@@ -0,0 +1,268 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cacheKey names one version of an input: a rewritten file gets a new key
+// and its stale entry ages out of the cache.
+type cacheKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	img  image.Image
+	size int64
+}
+
+// imageCache keeps the most recently used inputs decoded and shrunk to
+// at most maxSize pixels on their longer side, within max bytes.
+type imageCache struct {
+	mu sync.Mutex
+	// expanding serializes reading archives into memory, each version of
+	// an archive once, in archives; dir receives the members that are
+	// decoded from files.
+	expanding sync.Mutex
+	archives  map[cacheKey]bool
+	dir       string
+
+	max     int64
+	used    int64
+	maxSize int
+	opts    Options
+	order   *list.List
+	entries map[cacheKey]*list.Element
+	hits    int
+	misses  int
+}
+
+func newImageCache(max int64, maxSize int, opts Options, dir string) *imageCache {
+	return &imageCache{max: max, maxSize: maxSize, opts: opts, order: list.New(), entries: make(map[cacheKey]*list.Element), archives: make(map[cacheKey]bool), dir: dir}
+}
+
+// inputKey stats path, or the file before a "#" fragment such as an
+// archive entry or video frame, so a member's key changes with its archive.
+func inputKey(path string) (cacheKey, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return cacheKey{}, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		if i := strings.Index(abs, "#"); i >= 0 {
+			info, err = os.Stat(abs[:i])
+		}
+	}
+	if err != nil {
+		return cacheKey{}, err
+	}
+	return cacheKey{abs, info.Size(), info.ModTime()}, nil
+}
+
+func (c *imageCache) get(path string) (image.Image, error) {
+	key, err := inputKey(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.hits++
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).img, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	if err := c.expandArchive(key.path); err != nil {
+		return nil, err
+	}
+
+	// Decode outside the lock so one large file does not hold up renders
+	// of cached ones; two requests for the same new file both decode it.
+	img, err := c.opts.loadImage(key.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := img.(vectorImage); !ok && c.maxSize > 0 && math.Max(float64(Width(img)), float64(Height(img))) > float64(c.maxSize) {
+		if Width(img) >= Height(img) {
+			img = resizeWidth(img, c.maxSize)
+		} else {
+			img = resizeHeight(img, c.maxSize)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*cacheEntry).img, nil
+	}
+	entry := &cacheEntry{key, img, decodedSize(img)}
+	c.entries[key] = c.order.PushFront(entry)
+	c.used += entry.size
+	// Evict from the least recently used end, keeping the new entry even
+	// when it alone exceeds the budget.
+	for c.used > c.max && c.order.Len() > 1 {
+		e := c.order.Back()
+		old := c.order.Remove(e).(*cacheEntry)
+		delete(c.entries, old.key)
+		c.used -= old.size
+	}
+	return img, nil
+}
+
+// expandArchive reads the archive of a "photos.zip#entry=beach.jpg" path
+// into memory unless this version of it was read already. A rewritten
+// archive overwrites the members it shares with its earlier version.
+func (c *imageCache) expandArchive(path string) error {
+	i := strings.LastIndex(path, "#entry=")
+	if i < 0 {
+		return nil
+	}
+	key, err := inputKey(path[:i])
+	if err != nil {
+		return err
+	}
+	c.expanding.Lock()
+	defer c.expanding.Unlock()
+	if c.archives[key] {
+		return nil
+	}
+	if _, err := expandArchives([]string{path[:i]}, c.dir); err != nil {
+		return err
+	}
+	c.archives[key] = true
+	return nil
+}
+
+func daemonMain(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	configureLogging := addLogFlags(fs)
+	socket := fs.String("socket", filepath.Join(os.TempDir(), "imagecollager.sock"), "Unix socket to serve render requests on")
+	cacheSize := fs.String("cache-size", "1G", "memory for decoded images, such as 512M or 2G; the least recently used are dropped first")
+	maxSize := fs.Int("max-size", 2048, "longer side in pixels cached images are shrunk to, 0 to keep them whole")
+	raw := fs.String("raw", "auto", "camera raw handling: decode (dcraw/libraw), preview (embedded JPEG) or auto")
+	fs.Parse(args)
+	configureLogging()
+
+	budget, err := parseByteSize(*cacheSize)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if *maxSize < 0 {
+		logger.Fatal("Max size must not be negative")
+	}
+	dir, err := ioutil.TempDir("", "imagecollager-daemon-")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.onFatal(func() { os.RemoveAll(dir) })
+	defer os.RemoveAll(dir)
+	cache := newImageCache(budget, *maxSize, Options{raw: RawMode(*raw)}, dir)
+
+	// A socket left behind by a daemon that did not shut down cleanly
+	// refuses the bind; one still answering is another running daemon.
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		logger.Fatalf("A daemon is already serving %s", *socket)
+	}
+	os.Remove(*socket)
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := os.Chmod(*socket, 0600); err != nil {
+		logger.Fatal(err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		l.Close()
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/render", cache.handleRender)
+	mux.HandleFunc("/status", cache.handleStatus)
+	logger.Infof("Serving renders on %s", *socket)
+	if err := http.Serve(l, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Fatal(err)
+	}
+}
+
+// handleRender renders the images named by the repeated path field with
+// the options of the web UI's form, as a PNG:
+//
+//	curl --unix-socket /tmp/imagecollager.sock -d rows=2 -d path=/photos/a.jpg -d path=/photos/b.jpg http://daemon/render
+//
+// Relative paths resolve against the daemon's working directory.
+func (c *imageCache) handleRender(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	start := time.Now()
+	paths := r.Form["path"]
+	images := make([]image.Image, len(paths))
+	for i, path := range paths {
+		img, err := c.get(path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", path, err), http.StatusBadRequest)
+			return
+		}
+		images[i] = img
+	}
+	output, err := renderForm(r.Form, images)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, output.value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Debugf("Rendered %d images in %v", len(paths), time.Since(start))
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+func (c *imageCache) handleStatus(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	status := map[string]int64{
+		"images": int64(c.order.Len()),
+		"bytes":  c.used,
+		"limit":  c.max,
+		"hits":   int64(c.hits),
+		"misses": int64(c.misses),
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
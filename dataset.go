@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// classPalette is the ten-color categorical palette used for class borders.
+var classPalette = []color.RGBA{
+	{31, 119, 180, 255}, {255, 127, 14, 255}, {44, 160, 44, 255}, {214, 39, 40, 255}, {148, 103, 189, 255},
+	{140, 86, 75, 255}, {227, 119, 194, 255}, {127, 127, 127, 255}, {188, 189, 34, 255}, {23, 190, 207, 255},
+}
+
+const classBorderWidth = 3
+
+type DatasetRecord struct {
+	path   string
+	fields map[string]string
+}
+
+type DatasetOptions struct {
+	labelField   string
+	scoreField   string
+	columns      int
+	pageSize     int
+	classBorders bool
+}
+
+// readDataset reads a CSV with a header row. The "path" column is required;
+// relative paths are resolved against the CSV's directory.
+func readDataset(csvPath string) ([]DatasetRecord, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New(csvPath + ": empty dataset")
+	}
+
+	header := rows[0]
+	pathCol := -1
+	for i, name := range header {
+		if name == "path" {
+			pathCol = i
+		}
+	}
+	if pathCol < 0 {
+		return nil, errors.New(csvPath + `: no "path" column in header`)
+	}
+
+	records := make([]DatasetRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		r := DatasetRecord{fields: make(map[string]string)}
+		for i, name := range header {
+			r.fields[name] = row[i]
+		}
+		r.path = row[pathCol]
+		if !filepath.IsAbs(r.path) {
+			r.path = filepath.Join(filepath.Dir(csvPath), r.path)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func (r DatasetRecord) score(field string) (float64, bool) {
+	v, err := strconv.ParseFloat(r.fields[field], 64)
+	return v, err == nil
+}
+
+// classColors assigns palette colors to the sorted values of field.
+func classColors(records []DatasetRecord, field string) map[string]color.Color {
+	var values []string
+	seen := make(map[string]bool)
+	for _, r := range records {
+		if v := r.fields[field]; !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+
+	colors := make(map[string]color.Color, len(values))
+	for i, v := range values {
+		colors[v] = classPalette[i%len(classPalette)]
+	}
+	return colors
+}
+
+// fitTile scales img to fit inside a width x height box, keeping its aspect.
+func fitTile(img image.Image, width int, height int) image.Image {
+	if float64(Width(img))/float64(Height(img)) > float64(width)/float64(height) {
+		return resizeWidth(img, width)
+	}
+	return resizeHeight(img, height)
+}
+
+func (bgImg *MyImage) drawFrame(r image.Rectangle, thickness int, c color.Color) {
+	src := image.NewUniform(c)
+	for _, side := range []image.Rectangle{
+		{r.Min, image.Point{r.Max.X, r.Min.Y + thickness}},
+		{image.Point{r.Min.X, r.Max.Y - thickness}, r.Max},
+		{r.Min, image.Point{r.Min.X + thickness, r.Max.Y}},
+		{image.Point{r.Max.X - thickness, r.Min.Y}, r.Max},
+	} {
+		draw.Draw(bgImg, side, src, image.ZP, draw.Src)
+	}
+}
+
+// makeDatasetSheets renders records grouped by label, highest score first,
+// splitting them into pages of at most dopts.pageSize tiles.
+func makeDatasetSheets(opts Options, dopts DatasetOptions, records []DatasetRecord) ([]*MyImage, error) {
+	sort.SliceStable(records, func(i, j int) bool {
+		li, lj := records[i].fields[dopts.labelField], records[j].fields[dopts.labelField]
+		if li != lj {
+			return li < lj
+		}
+		si, _ := records[i].score(dopts.scoreField)
+		sj, _ := records[j].score(dopts.scoreField)
+		return si > sj
+	})
+
+	pageSize := dopts.pageSize
+	if pageSize <= 0 {
+		pageSize = len(records)
+	}
+	colors := classColors(records, dopts.labelField)
+
+	var pages []*MyImage
+	for start := 0; start < len(records); start += pageSize {
+		end := start + pageSize
+		if end > len(records) {
+			end = len(records)
+		}
+		page, err := makeDatasetPage(opts, dopts, colors, records[start:end])
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+func makeDatasetPage(opts Options, dopts DatasetOptions, colors map[string]color.Color, records []DatasetRecord) (*MyImage, error) {
+	padding := 4
+	cell := (opts.width - (dopts.columns+1)*padding) / dopts.columns
+	if cell < 1 {
+		return nil, fmt.Errorf("dataset sheet: %d columns do not fit %d pixels wide", dopts.columns, opts.width)
+	}
+	headerHeight := labelHeight + 6
+	captionHeight := labelHeight + 2
+
+	var groups [][]DatasetRecord
+	for i, r := range records {
+		if i == 0 || r.fields[dopts.labelField] != records[i-1].fields[dopts.labelField] {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], r)
+	}
+
+	height := padding
+	for _, g := range groups {
+		rows := (len(g) + dopts.columns - 1) / dopts.columns
+		height += headerHeight + rows*(cell+captionHeight+padding)
+	}
+
+	rectangleEnd := image.Point{dopts.columns*(cell+padding) + padding, height}
+	output := opts.newCanvas(image.Rectangle{image.ZP, rectangleEnd})
+
+	sp_y := padding
+	for _, g := range groups {
+		label := g[0].fields[dopts.labelField]
+		output.drawString(fmt.Sprintf("%s (%d)", label, len(g)), padding, sp_y+3+labelFace.Ascent, color.White)
+		sp_y += headerHeight
+
+		for i, r := range g {
+			img, err := opts.loadImage(r.path)
+			if err != nil {
+				return nil, err
+			}
+			img = opts.filterTile(img)
+
+			col := i % dopts.columns
+			cellRect := image.Rect(padding+col*(cell+padding), sp_y, padding+col*(cell+padding)+cell, sp_y+cell)
+			inner := cellRect
+			if dopts.classBorders {
+				output.drawFrame(cellRect, classBorderWidth, colors[label])
+				inner = cellRect.Inset(classBorderWidth)
+			}
+
+			tile := fitTile(img, inner.Dx(), inner.Dy())
+			output.drawRaw(tile, image.Point{inner.Min.X + (inner.Dx()-Width(tile))/2, inner.Min.Y + (inner.Dy()-Height(tile))/2})
+
+			if score, ok := r.score(dopts.scoreField); ok {
+				output.drawString(strconv.FormatFloat(score, 'f', 3, 64), cellRect.Min.X, cellRect.Max.Y+1+labelFace.Ascent, color.White)
+			}
+
+			if col == dopts.columns-1 || i == len(g)-1 {
+				sp_y += cell + captionHeight + padding
+			}
+		}
+	}
+
+	return &output, nil
+}
+
+// makeConfusionSheet places samples in a matrix with one row per value of
+// rowField (e.g. true label) and one column per value of colField (e.g.
+// predicted label). Both axes share the sorted union of values so that the
+// diagonal holds the correct predictions. Each cell shows up to samples
+// images, highest score first, and the total count.
+func makeConfusionSheet(opts Options, dopts DatasetOptions, rowField string, colField string, samples int, records []DatasetRecord) (*MyImage, error) {
+	classes := make(map[string]int)
+	var values []string
+	for _, r := range records {
+		for _, v := range []string{r.fields[rowField], r.fields[colField]} {
+			if _, ok := classes[v]; !ok {
+				classes[v] = 0
+				values = append(values, v)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, errors.New("confusion sheet: no records")
+	}
+	sort.Strings(values)
+	for i, v := range values {
+		classes[v] = i
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		si, _ := records[i].score(dopts.scoreField)
+		sj, _ := records[j].score(dopts.scoreField)
+		return si > sj
+	})
+	cells := make([][][]DatasetRecord, len(values))
+	for i := range cells {
+		cells[i] = make([][]DatasetRecord, len(values))
+	}
+	for _, r := range records {
+		row, col := classes[r.fields[rowField]], classes[r.fields[colField]]
+		cells[row][col] = append(cells[row][col], r)
+	}
+
+	padding := 2
+	labelWidth := textWidth(rowField)
+	for _, v := range values {
+		if w := textWidth(v); w > labelWidth {
+			labelWidth = w
+		}
+	}
+	labelWidth += 8
+	headerHeight := 2*labelHeight + 8
+	n := len(values)
+	cell := (opts.width - labelWidth - (n+1)*padding) / n
+	perSide := int(math.Ceil(math.Sqrt(float64(samples))))
+	sample := (cell - (perSide+1)*padding) / perSide
+	if sample < 1 {
+		return nil, fmt.Errorf("confusion sheet: %d classes with %d samples each do not fit %d pixels wide", n, samples, opts.width)
+	}
+
+	rectangleEnd := image.Point{labelWidth + n*(cell+padding) + padding, headerHeight + n*(cell+padding) + padding}
+	output := opts.newCanvas(image.Rectangle{image.ZP, rectangleEnd})
+
+	output.drawString(colField, labelWidth+padding, 2+labelFace.Ascent, color.White)
+	output.drawString(rowField, 4, headerHeight-4-labelHeight+labelFace.Ascent, color.White)
+	for i, v := range values {
+		x := labelWidth + padding + i*(cell+padding)
+		output.drawString(v, x+(cell-textWidth(v))/2, headerHeight-4-labelHeight+labelFace.Ascent, color.White)
+		y := headerHeight + padding + i*(cell+padding)
+		output.drawString(v, 4, y+(cell-labelHeight)/2+labelFace.Ascent, color.White)
+	}
+
+	grid := color.Gray{96}
+	for row := range cells {
+		for col, group := range cells[row] {
+			sp := image.Point{labelWidth + padding + col*(cell+padding), headerHeight + padding + row*(cell+padding)}
+			cellRect := image.Rectangle{sp, sp.Add(image.Point{cell, cell})}
+			if row == col {
+				output.drawFrame(cellRect, 1, color.White)
+			} else {
+				output.drawFrame(cellRect, 1, grid)
+			}
+
+			for i, r := range group {
+				if i == samples {
+					break
+				}
+				img, err := opts.loadImage(r.path)
+				if err != nil {
+					return nil, err
+				}
+				img = opts.filterTile(img)
+
+				slot := image.Point{sp.X + padding + (i%perSide)*(sample+padding), sp.Y + padding + (i/perSide)*(sample+padding)}
+				tile := fitTile(img, sample, sample)
+				output.drawRaw(tile, slot.Add(image.Point{(sample - Width(tile)) / 2, (sample - Height(tile)) / 2}))
+			}
+
+			if len(group) > 0 {
+				count := "n=" + strconv.Itoa(len(group))
+				box := image.Rect(cellRect.Max.X-textWidth(count)-6, cellRect.Max.Y-labelHeight-3, cellRect.Max.X-1, cellRect.Max.Y-1)
+				draw.Draw(&output, box, image.NewUniform(color.NRGBA{0, 0, 0, 180}), image.ZP, draw.Over)
+				output.drawString(count, box.Min.X+3, box.Min.Y+1+labelFace.Ascent, color.White)
+			}
+		}
+	}
+
+	return &output, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	exifTagMake             = 0x010f
+	exifTagModel            = 0x0110
+	exifTagDateTime         = 0x0132
+	exifTagExposureTime     = 0x829a
+	exifTagFNumber          = 0x829d
+	exifTagExifIFD          = 0x8769
+	exifTagISO              = 0x8827
+	exifTagDateTimeOriginal = 0x9003
+	exifTagFocalLength      = 0x920a
+	exifTagLensModel        = 0xa434
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+var errNoExif = errors.New("exif: no metadata found")
+
+type exifField struct {
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+// Exif holds the IFD0 and Exif sub-IFD entries of an image, keyed by tag.
+type Exif struct {
+	order  binary.ByteOrder
+	fields map[uint16]exifField
+}
+
+var exifTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+func readExifFile(path string) (*Exif, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) == "II*\x00" || string(magic) == "MM\x00*" {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseExif(data)
+	}
+	if magic[0] != 0xff || magic[1] != 0xd8 {
+		return nil, errNoExif
+	}
+	return readJPEGExif(r)
+}
+
+// readJPEGExif walks the JPEG marker segments up to the start of scan,
+// looking for the APP1 segment that carries the Exif TIFF structure.
+func readJPEGExif(r *bufio.Reader) (*Exif, error) {
+	if _, err := r.Discard(2); err != nil {
+		return nil, err
+	}
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xff || marker[1] == 0xda {
+			return nil, errNoExif
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, errNoExif
+		}
+		if marker[1] != 0xe1 {
+			if _, err := r.Discard(length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseExif(segment[6:])
+		}
+	}
+}
+
+func parseExif(data []byte) (*Exif, error) {
+	if len(data) < 8 {
+		return nil, errNoExif
+	}
+
+	e := &Exif{fields: make(map[uint16]exifField)}
+	switch string(data[:2]) {
+	case "II":
+		e.order = binary.LittleEndian
+	case "MM":
+		e.order = binary.BigEndian
+	default:
+		return nil, errNoExif
+	}
+
+	if err := e.readIFD(data, e.order.Uint32(data[4:])); err != nil {
+		return nil, err
+	}
+	if sub, ok := e.fields[exifTagExifIFD]; ok && len(sub.data) >= 4 {
+		if err := e.readIFD(data, e.order.Uint32(sub.data)); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *Exif) readIFD(data []byte, offset uint32) error {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return errors.New("exif: IFD offset out of range")
+	}
+	n := uint32(e.order.Uint16(data[offset:]))
+	entries := offset + 2
+	if uint64(entries)+uint64(n)*12 > uint64(len(data)) {
+		return errors.New("exif: truncated IFD")
+	}
+
+	for i := uint32(0); i < n; i++ {
+		entry := data[entries+i*12:]
+		tag := e.order.Uint16(entry)
+		typ := e.order.Uint16(entry[2:])
+		count := e.order.Uint32(entry[4:])
+
+		size, ok := exifTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(count)
+		value := entry[8:12]
+		if total > 4 {
+			start := uint64(e.order.Uint32(entry[8:]))
+			if start+total > uint64(len(data)) {
+				continue
+			}
+			value = data[start : start+total]
+		}
+		e.fields[tag] = exifField{typ, count, value[:total]}
+	}
+	return nil
+}
+
+func (e *Exif) String(tag uint16) (string, bool) {
+	f, ok := e.fields[tag]
+	if !ok || f.typ != 2 {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimRight(string(f.data), "\x00")), true
+}
+
+func (e *Exif) Int(tag uint16) (int, bool) {
+	f, ok := e.fields[tag]
+	if !ok || f.count == 0 {
+		return 0, false
+	}
+	switch f.typ {
+	case 3:
+		return int(e.order.Uint16(f.data)), true
+	case 4:
+		return int(e.order.Uint32(f.data)), true
+	}
+	return 0, false
+}
+
+func (e *Exif) Rational(tag uint16) (float64, bool) {
+	f, ok := e.fields[tag]
+	if !ok || f.count == 0 || (f.typ != 5 && f.typ != 10) {
+		return 0, false
+	}
+	num, den := float64(e.order.Uint32(f.data)), float64(e.order.Uint32(f.data[4:]))
+	if f.typ == 10 {
+		num, den = float64(int32(e.order.Uint32(f.data))), float64(int32(e.order.Uint32(f.data[4:])))
+	}
+	if den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
+
+func (e *Exif) Time(tag uint16) (time.Time, bool) {
+	s, ok := e.String(tag)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(exifTimeLayout, s, time.Local)
+	return t, err == nil
+}
+
+// captureTime prefers the Exif original capture date and falls back to the
+// file modification time for images without usable metadata.
+func captureTime(path string) (time.Time, error) {
+	if e, err := readExifFile(path); err == nil {
+		if t, ok := e.Time(exifTagDateTimeOriginal); ok {
+			return t, nil
+		}
+		if t, ok := e.Time(exifTagDateTime); ok {
+			return t, nil
+		}
+	}
+
+	return inputModTime(path)
+}
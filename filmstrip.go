@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+var (
+	filmBase = color.RGBA{0x14, 0x14, 0x14, 0xff}
+	filmHole = color.RGBA{0xf0, 0xf0, 0xf0, 0xff}
+)
+
+// makeFilmstrip lays images in a single row scaled to the same height, or
+// with vertical in a single column scaled to the same width, on dark film
+// base. The strip is as long as the tiles need. With sprockets a band of
+// rounded holes runs along both edges.
+func makeFilmstrip(opts Options, images []image.Image, size int, vertical bool, sprockets bool) *MyImage {
+	gap := size / 20
+	if gap < 2 {
+		gap = 2
+	}
+	band := 0
+	if sprockets {
+		band = size / 6
+		if band < 8 {
+			band = 8
+		}
+	}
+
+	// Work along and across the strip, then swap the axes when vertical.
+	rect := func(along0, across0, along1, across1 int) image.Rectangle {
+		if vertical {
+			return image.Rect(across0, along0, across1, along1)
+		}
+		return image.Rect(along0, across0, along1, across1)
+	}
+	tiles := make([]image.Image, len(images))
+	length := gap
+	for i, img := range images {
+		if vertical {
+			tiles[i] = resizeWidth(img, size)
+			length += Height(tiles[i]) + gap
+		} else {
+			tiles[i] = resizeHeight(img, size)
+			length += Width(tiles[i]) + gap
+		}
+	}
+	across := size + 2*gap + 2*band
+
+	output := opts.newCanvas(rect(0, 0, length, across))
+	draw.Draw(&output, output.Bounds(), image.NewUniform(filmBase), image.ZP, draw.Src)
+
+	at := gap
+	for _, tile := range tiles {
+		if vertical {
+			output.drawRaw(tile, image.Pt(band+gap, at))
+			at += Height(tile) + gap
+		} else {
+			output.drawRaw(tile, image.Pt(at, band+gap))
+			at += Width(tile) + gap
+		}
+	}
+
+	if sprockets {
+		holeLength, holeAcross := band*3/4, band/2
+		pitch := band * 3 / 2
+		n := length / pitch
+		start := (length-n*pitch)/2 + (pitch-holeLength)/2
+		hole := image.NewUniform(filmHole)
+		for i := 0; i < n; i++ {
+			a := start + i*pitch
+			for _, edge := range []int{(band - holeAcross) / 2, across - band + (band-holeAcross)/2} {
+				r := rect(a, edge, a+holeLength, edge+holeAcross)
+				draw.DrawMask(&output, r, hole, image.ZP, &RoundedRect{r, holeAcross / 4}, r.Min, draw.Over)
+			}
+		}
+	}
+
+	return &output
+}
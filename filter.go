@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// ColorFilter transforms a single non-premultiplied color. Filters work
+// at 16 bits a channel; 8-bit tiles are widened and narrowed back exactly.
+type ColorFilter func(c color.NRGBA64) color.NRGBA64
+
+func luma(c color.NRGBA64) float64 {
+	return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+}
+
+func clampByte(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func clampWord(v float64) uint16 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xffff {
+		return 0xffff
+	}
+	return uint16(v + 0.5)
+}
+
+func grayscaleFilter(c color.NRGBA64) color.NRGBA64 {
+	y := clampWord(luma(c))
+	return color.NRGBA64{y, y, y, c.A}
+}
+
+func sepiaFilter(c color.NRGBA64) color.NRGBA64 {
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	return color.NRGBA64{
+		clampWord(0.393*r + 0.769*g + 0.189*b),
+		clampWord(0.349*r + 0.686*g + 0.168*b),
+		clampWord(0.272*r + 0.534*g + 0.131*b),
+		c.A,
+	}
+}
+
+// tintFilter maps luminance onto a black - tint - white ramp, so shadows
+// stay dark and highlights stay bright while midtones take the tint color.
+func tintFilter(tint color.Color) ColorFilter {
+	t := widen(color.NRGBAModel.Convert(tint).(color.NRGBA))
+	return func(c color.NRGBA64) color.NRGBA64 {
+		y := luma(c) / 0xffff
+		ramp := func(v uint16) uint16 {
+			if y < 0.5 {
+				return clampWord(float64(v) * y * 2)
+			}
+			return clampWord(float64(v) + (0xffff-float64(v))*(y-0.5)*2)
+		}
+		return color.NRGBA64{ramp(t.R), ramp(t.G), ramp(t.B), c.A}
+	}
+}
+
+// curveFilter applies the same curve to the red, green and blue channels.
+func curveFilter(curve func(v float64) float64) ColorFilter {
+	table := make([]uint16, 0x10000)
+	for i := range table {
+		table[i] = clampWord(curve(float64(i)))
+	}
+	return func(c color.NRGBA64) color.NRGBA64 {
+		return color.NRGBA64{table[c.R], table[c.G], table[c.B], c.A}
+	}
+}
+
+// widen and narrow move between 8 and 16 bits a channel. A filter that
+// scales with its input, as these do, gives 257 times its 8-bit result,
+// which narrow rounds back to that result.
+func widen(c color.NRGBA) color.NRGBA64 {
+	return color.NRGBA64{uint16(c.R) * 257, uint16(c.G) * 257, uint16(c.B) * 257, uint16(c.A) * 257}
+}
+
+func narrow(c color.NRGBA64) color.NRGBA {
+	n := func(v uint16) uint8 { return uint8((uint32(v) + 128) / 257) }
+	return color.NRGBA{n(c.R), n(c.G), n(c.B), n(c.A)}
+}
+
+func parseFilter(spec string) (ColorFilter, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "grayscale":
+		return grayscaleFilter, nil
+	case spec == "sepia":
+		return sepiaFilter, nil
+	case strings.HasPrefix(spec, "tint:"):
+		c, err := parseHexColor(strings.TrimPrefix(spec, "tint:"))
+		if err != nil {
+			return nil, err
+		}
+		return tintFilter(c), nil
+	}
+	return nil, fmt.Errorf("unknown filter effect %q, expected grayscale, sepia or tint:#rrggbb", spec)
+}
+
+// filteredVector filters a vector image as it is rasterized, so it is
+// still drawn at the size of its tile.
+type filteredVector struct {
+	vectorImage
+	filter ColorFilter
+	deep   bool
+}
+
+func (v *filteredVector) At(x, y int) color.Color {
+	return v.filter(color.NRGBA64Model.Convert(v.vectorImage.At(x, y)).(color.NRGBA64))
+}
+
+func (v *filteredVector) Rasterize(width int, height int) image.Image {
+	return v.filter.apply(v.vectorImage.Rasterize(width, height), v.deep)
+}
+
+// apply filters img into a new image, at 16 bits a channel when img is
+// deep or deep is set and at 8 bits otherwise. Vector images are filtered
+// when they are rasterized.
+func (f ColorFilter) apply(img image.Image, deep bool) image.Image {
+	if v, ok := img.(vectorImage); ok {
+		return &filteredVector{v, f, deep}
+	}
+	b := img.Bounds()
+	if deep || isDeep(img) {
+		out := image.NewNRGBA64(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				out.SetNRGBA64(x, y, f(color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)))
+			}
+		}
+		return out
+	}
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out.SetNRGBA(x, y, narrow(f(widen(color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)))))
+		}
+	}
+	return out
+}
+
+// filterTile applies the global filter effect, if any, to a tile image.
+func (o Options) filterTile(img image.Image) image.Image {
+	if o.filter == nil {
+		return img
+	}
+	return o.filter.apply(img, o.deep)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	fitsBlockSize = 2880
+	fitsCardSize  = 80
+)
+
+// FITSImage keeps the physical data range of a FITS image, which is
+// linearly mapped onto the full 16-bit gray range for display.
+type FITSImage struct {
+	*image.Gray16
+	min float64
+	max float64
+}
+
+func (f *FITSImage) ValueRange() (float64, float64) {
+	return f.min, f.max
+}
+
+type fitsHeader struct {
+	bitpix int
+	naxis  int
+	width  int
+	height int
+	bzero  float64
+	bscale float64
+}
+
+func init() {
+	image.RegisterFormat("fits", "SIMPLE  =", decodeFITS, decodeFITSConfig)
+}
+
+func readFITSHeader(r io.Reader) (fitsHeader, error) {
+	h := fitsHeader{bscale: 1}
+	block := make([]byte, fitsBlockSize)
+	for {
+		if _, err := io.ReadFull(r, block); err != nil {
+			return h, err
+		}
+
+		for i := 0; i < fitsBlockSize; i += fitsCardSize {
+			card := string(block[i : i+fitsCardSize])
+			key := strings.TrimSpace(card[:8])
+			if key == "END" {
+				if h.naxis < 2 || h.width <= 0 || h.height <= 0 {
+					return h, errors.New("fits: primary HDU holds no 2D image")
+				}
+				return h, nil
+			}
+
+			if card[8:10] != "= " {
+				continue
+			}
+			value := card[10:]
+			if j := strings.IndexByte(value, '/'); j >= 0 {
+				value = value[:j]
+			}
+			value = strings.TrimSpace(value)
+
+			var err error
+			switch key {
+			case "BITPIX":
+				h.bitpix, err = strconv.Atoi(value)
+			case "NAXIS":
+				h.naxis, err = strconv.Atoi(value)
+			case "NAXIS1":
+				h.width, err = strconv.Atoi(value)
+			case "NAXIS2":
+				h.height, err = strconv.Atoi(value)
+			case "BZERO":
+				h.bzero, err = strconv.ParseFloat(value, 64)
+			case "BSCALE":
+				h.bscale, err = strconv.ParseFloat(value, 64)
+			}
+			if err != nil {
+				return h, errors.New("fits: invalid " + key + " value")
+			}
+		}
+	}
+}
+
+func decodeFITSConfig(r io.Reader) (image.Config, error) {
+	h, err := readFITSHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.Gray16Model, Width: h.width, Height: h.height}, nil
+}
+
+// decodeFITS reads the first plane of the primary HDU. FITS stores rows
+// bottom-up, so the image is flipped to the usual top-down orientation.
+func decodeFITS(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readFITSHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var read func() (float64, error)
+	switch h.bitpix {
+	case 8:
+		read = func() (float64, error) {
+			b, err := br.ReadByte()
+			return float64(b), err
+		}
+	case 16:
+		read = func() (float64, error) {
+			var v int16
+			err := binary.Read(br, binary.BigEndian, &v)
+			return float64(v), err
+		}
+	case 32:
+		read = func() (float64, error) {
+			var v int32
+			err := binary.Read(br, binary.BigEndian, &v)
+			return float64(v), err
+		}
+	case 64:
+		read = func() (float64, error) {
+			var v int64
+			err := binary.Read(br, binary.BigEndian, &v)
+			return float64(v), err
+		}
+	case -32:
+		read = func() (float64, error) {
+			var v float32
+			err := binary.Read(br, binary.BigEndian, &v)
+			return float64(v), err
+		}
+	case -64:
+		read = func() (float64, error) {
+			var v float64
+			err := binary.Read(br, binary.BigEndian, &v)
+			return v, err
+		}
+	default:
+		return nil, errors.New("fits: unsupported BITPIX " + strconv.Itoa(h.bitpix))
+	}
+
+	values := make([]float64, h.width*h.height)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i := range values {
+		raw, err := read()
+		if err != nil {
+			return nil, err
+		}
+		v := h.bzero + h.bscale*raw
+		values[i] = v
+		if math.IsNaN(v) {
+			continue
+		}
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	if lo > hi {
+		lo, hi = 0, 0
+	}
+
+	img := &FITSImage{image.NewGray16(image.Rect(0, 0, h.width, h.height)), lo, hi}
+	span := hi - lo
+	for i, v := range values {
+		g := uint16(0)
+		if !math.IsNaN(v) && span > 0 {
+			g = uint16(math.Round((v - lo) / span * 0xffff))
+		}
+		img.SetGray16(i%h.width, h.height-1-i/h.width, color.Gray16{g})
+	}
+	return img, nil
+}
@@ -1,17 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"log"
+	_ "image/png"
 	"math"
-	"os"
-	"sort"
 	"strconv"
+	"strings"
 
-	"github.com/fogleman/imview"
 	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
 )
 
 func Width(i image.Image) int {
@@ -23,7 +25,7 @@ func Height(i image.Image) int {
 }
 
 type MyImage struct {
-	value *image.RGBA
+	value draw.Image
 }
 
 func (i *MyImage) Set(x, y int, c color.Color) {
@@ -42,6 +44,16 @@ func (i *MyImage) At(x, y int) color.Color {
 	return i.value.At(x, y)
 }
 
+// unwrap returns the canvas behind a rendered collage so that collages fed
+// back in as tiles take the resize fast path for *image.RGBA and
+// *image.RGBA64.
+func unwrap(img image.Image) image.Image {
+	if m, ok := img.(*MyImage); ok {
+		return m.value
+	}
+	return img
+}
+
 type Circle struct {
 	p image.Point
 	r int
@@ -68,6 +80,125 @@ type Size struct {
 	height uint
 }
 
+type Options struct {
+	width        int
+	height       int
+	rows         int
+	shape        ImageShape
+	integerScale bool
+	scaleBars    bool
+	emptyColor   color.Color
+	captions     map[image.Image][]string
+	captionAlign CaptionAlign
+	captionFace  font.Face
+	rotations    map[image.Image]float64
+	filter       ColorFilter
+	normalize    Normalization
+
+	vignette        float64
+	vignetteFalloff float64
+
+	deep        bool
+	raw         RawMode
+	videoFrames int
+	frame       FrameSelection
+
+	// lenient turns inputs that fail to load into labeled placeholder
+	// tiles instead of exiting.
+	lenient bool
+
+	// keepOrder lays images out in the order given instead of tallest
+	// first, and placements, when non-nil, receives the rectangle each tile
+	// was drawn in. The interactive viewer uses both to rearrange tiles.
+	keepOrder  bool
+	placements map[image.Image]image.Rectangle
+
+	// inputDir receives the remote downloads and stdin archives of this run.
+	inputDir string
+
+	// padding replaces the default gap between tiles when paddingSet.
+	padding    int
+	paddingSet bool
+
+	pack       PackStrategy
+	separators Separators
+	border     Border
+
+	// rowSpec, when non-nil, is the number of tiles in each of the rows,
+	// overriding pack.
+	rowSpec []int
+
+	// styles overrides shape, border and width share per tile.
+	styles map[image.Image]TileStyle
+
+	// columns is the column count of the masonry layout, about the square
+	// root of the image count when zero.
+	columns int
+
+	// weights sizes the cells of the treemap layout, 1 for images without,
+	// and the circles of the circles layout.
+	weights map[image.Image]float64
+
+	// blend and opacity composite overlapping tiles; zero values draw
+	// tiles opaque and over each other.
+	blend   BlendMode
+	opacity float64
+
+	// paper, when non-zero, is the print size in pixels every page is
+	// centered on before saving, and encoding carries its resolution.
+	paper    image.Point
+	encoding Encoding
+	// margin is kept clear of the page on every side of the paper, and
+	// safeTop and safeBottom at its top and bottom where wider.
+	margin     int
+	safeTop    int
+	safeBottom int
+}
+
+// tilePadding is the gap between grid tiles: 1 pixel for rectangles and 20
+// for circles unless overridden, and at least as wide as the separators.
+func (o Options) tilePadding() int {
+	padding := 1
+	if o.paddingSet {
+		padding = o.padding
+	} else if o.shape == CircleShape {
+		padding = 20
+	}
+	if s := o.separators; (s.horizontal || s.vertical) && s.width > padding {
+		padding = s.width
+	}
+	return padding
+}
+
+// newCanvas allocates the collage canvas, 16 bits per channel when deep
+// output was requested so that 16-bit inputs keep their precision.
+func (o Options) newCanvas(r image.Rectangle) MyImage {
+	if o.deep {
+		return MyImage{image.NewRGBA64(r)}
+	}
+	return MyImage{image.NewRGBA(r)}
+}
+
+func isDeep(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return true
+	}
+	return false
+}
+
+func parseHexColor(s string) (color.Color, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 6 {
+		s += "ff"
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 8 {
+		return nil, fmt.Errorf("invalid color %q, expected #rrggbb or #rrggbbaa", "#"+s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
 type ImageShape string
 
 const (
@@ -76,78 +207,232 @@ const (
 	CircleDiameter            = 0.8
 )
 
-func drawLine(img *image.RGBA, line_width int, space_from_end_x int, space_from_end_y int) {
-	for i := img.Bounds().Max.X - line_width - space_from_end_x; i < img.Bounds().Max.X-space_from_end_x; i++ {
-		img.Set(i, img.Bounds().Max.Y-space_from_end_y, color.RGBA{255, 255, 255, 255})
+// Separators are divider lines drawn in the gutters between grid tiles:
+// horizontal ones between rows and vertical ones between the tiles of a
+// row.
+type Separators struct {
+	horizontal bool
+	vertical   bool
+	width      int
+	color      color.Color
+}
+
+func parseSeparators(s string, width int, c color.Color) (Separators, error) {
+	sep := Separators{width: width, color: c}
+	switch s {
+	case "":
+		return sep, nil
+	case "h", "horizontal":
+		sep.horizontal = true
+	case "v", "vertical":
+		sep.vertical = true
+	case "both":
+		sep.horizontal, sep.vertical = true, true
+	default:
+		return sep, fmt.Errorf("unknown separators %q; use horizontal, vertical or both", s)
+	}
+	if width < 1 {
+		return sep, fmt.Errorf("separator width must be at least 1, got %d", width)
 	}
+	return sep, nil
 }
 
-func (bgImg *MyImage) drawRaw(innerImg image.Image, sp image.Point, width uint, height uint) {
-	resizedImg := resize.Resize(width, height, innerImg, resize.Lanczos3)
-	w := int(Width(resizedImg))
-	h := int(Height(resizedImg))
-	draw.Draw(bgImg, image.Rectangle{sp, image.Point{sp.X + w, sp.Y + h}}, resizedImg, image.ZP, draw.Src)
+// drawLine draws a line of the given thickness and length from p, to the
+// right or, when vertical, downward.
+func drawLine(img draw.Image, p image.Point, length int, thickness int, vertical bool, c color.Color) {
+	r := image.Rect(p.X, p.Y, p.X+length, p.Y+thickness)
+	if vertical {
+		r = image.Rect(p.X, p.Y, p.X+thickness, p.Y+length)
+	}
+	draw.Draw(img, r, image.NewUniform(c), image.ZP, draw.Src)
 }
 
-func (bgImg *MyImage) drawInCircle(innerImg image.Image, sp image.Point, width uint, height uint, diameter int) {
-	resizedImg := resize.Resize(width, height, innerImg, resize.Lanczos3)
+func (bgImg *MyImage) drawRaw(innerImg image.Image, sp image.Point) {
+	w := Width(innerImg)
+	h := Height(innerImg)
+	draw.Draw(bgImg.value, image.Rectangle{sp, image.Point{sp.X + w, sp.Y + h}}, innerImg, image.ZP, draw.Src)
+}
 
+func (bgImg *MyImage) drawInCircle(innerImg image.Image, sp image.Point, diameter int, mode BlendMode, opacity float64) {
 	r := diameter
-	if r > Width(resizedImg) {
-		r = Width(resizedImg)
+	if r > Width(innerImg) {
+		r = Width(innerImg)
 	}
 
-	if r > Height(resizedImg) {
-		r = int(Height(resizedImg))
+	if r > Height(innerImg) {
+		r = int(Height(innerImg))
 	}
 
-	mask := &Circle{image.Point{Width(resizedImg) / 2, Height(resizedImg) / 2}, r / 2}
+	mask := &Circle{image.Point{Width(innerImg) / 2, Height(innerImg) / 2}, r / 2}
+
+	blendDraw(bgImg, image.Rectangle{sp, image.Point{sp.X + Width(innerImg), sp.Y + Height(innerImg)}}, innerImg, image.ZP, mask, image.ZP, mode, opacity)
+}
 
-	draw.DrawMask(bgImg, image.Rectangle{sp, image.Point{sp.X + Width(resizedImg), sp.Y + Height(resizedImg)}}, resizedImg, image.ZP, mask, image.ZP, draw.Over)
+func integerDownscale(img image.Image, k int) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA64(image.Rect(0, 0, b.Dx()/k, b.Dy()/k))
+	for y := 0; y < out.Bounds().Max.Y; y++ {
+		for x := 0; x < out.Bounds().Max.X; x++ {
+			out.Set(x, y, img.At(b.Min.X+x*k, b.Min.Y+y*k))
+		}
+	}
+	return out
 }
 
-func makeImageCollage(desiredWidth int, desiredHeight int, numberOfRows int, shape ImageShape, images ...image.Image) *MyImage {
+func cropCenter(img image.Image, width int, height int) image.Image {
+	if Width(img) == width && Height(img) == height {
+		return img
+	}
+	b := img.Bounds()
+	sp := image.Point{b.Min.X + (b.Dx()-width)/2, b.Min.Y + (b.Dy()-height)/2}
+	out := image.NewRGBA64(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), img, sp, draw.Src)
+	return out
+}
 
-	sort.Slice(images, func(i, j int) bool {
-		return Height(images[i]) > Height(images[j])
-	})
+// coverTile scales img to cover a width x height cell and crops the overflow
+// evenly from both sides.
+func coverTile(img image.Image, width int, height int) image.Image {
+	factor := math.Max(float64(width)/float64(Width(img)), float64(height)/float64(Height(img)))
+	w := uint(math.Ceil(float64(Width(img)) * factor))
+	h := uint(math.Ceil(float64(Height(img)) * factor))
+	return cropCenter(resample(img, w, h), width, height)
+}
 
-	numberOfColumns := len(images) / numberOfRows
-	imagesMatrix := make([][]image.Image, numberOfRows)
+func resizeWidth(img image.Image, width int) image.Image {
+	return resample(img, uint(width), 0)
+}
 
-	currentIndex := 0
-	maxNumberOfColumns := 0
-	for idx := 0; idx < numberOfRows; idx++ {
-		columnsInRow := numberOfColumns
-		if len(images)%numberOfRows > 0 && (numberOfRows-idx)*numberOfColumns < len(images)-currentIndex {
-			columnsInRow++
-		}
+func resizeHeight(img image.Image, height int) image.Image {
+	return resample(img, 0, uint(height))
+}
 
-		if columnsInRow > maxNumberOfColumns {
-			maxNumberOfColumns = columnsInRow
+// resample scales img with Lanczos3, or renders vector tiles directly at the
+// target size. A zero width or height keeps the aspect ratio, as in resize.
+func resample(img image.Image, width uint, height uint) image.Image {
+	defer stats.enter("resize")()
+	if v, ok := img.(vectorImage); ok {
+		if width == 0 {
+			width = uint(math.Round(float64(height) * float64(Width(img)) / float64(Height(img))))
 		}
+		if height == 0 {
+			height = uint(math.Round(float64(width) * float64(Height(img)) / float64(Width(img))))
+		}
+		return v.Rasterize(int(width), int(height))
+	}
+	return resize.Resize(width, height, unwrap(img), resize.Lanczos3)
+}
+
+// integerFactor is the smallest whole-number downscale that fits img into
+// calculatedWidth. Images narrower than the cell are never enlarged.
+func integerFactor(img image.Image, calculatedWidth float64) int {
+	return int(math.Max(1, math.Ceil(float64(Width(img))/calculatedWidth)))
+}
 
-		imagesMatrix[idx] = images[currentIndex : currentIndex+columnsInRow]
-		currentIndex += columnsInRow
+func (o Options) tileSize(img image.Image, calculatedWidth float64) Size {
+	originalWidth := float64(Width(img))
+	originalHeight := float64(Height(img))
+	if o.integerScale {
+		k := integerFactor(img, calculatedWidth)
+		return Size{uint(Width(img) / k), uint(Height(img) / k)}
+	}
+
+	resizeFactor := calculatedWidth / originalWidth
+	return Size{uint(originalWidth * resizeFactor), uint(originalHeight * resizeFactor)}
+}
+
+func (o Options) scaleTile(img image.Image, calculatedWidth float64, width uint, height uint) image.Image {
+	if o.integerScale {
+		return cropCenter(integerDownscale(img, integerFactor(img, calculatedWidth)), int(width), int(height))
+	}
+	return resample(img, width, height)
+}
+
+func (o Options) footerHeight() int {
+	height := o.captionHeight()
+	if o.scaleBars {
+		height += scaleBarHeight
+	}
+	return height
+}
+
+// validateRows checks a grid row count against the number of tiles: every
+// row needs at least one image.
+func validateRows(rows int, images int) error {
+	switch {
+	case images == 0:
+		return errors.New("no images defined")
+	case rows < 1:
+		return fmt.Errorf("number of rows must be at least 1, got %d", rows)
+	case rows > images:
+		return fmt.Errorf("%d rows for %d images would leave rows empty; use at most %d rows", rows, images, images)
+	}
+	return nil
+}
+
+// gridCell is one planned grid tile: the cell width its row gives it, the
+// size it is drawn at, square for circles, and its top-left corner.
+type gridCell struct {
+	img   image.Image
+	width float64
+	size  Size
+	at    image.Point
+}
+
+// gridRow is one row of a planned grid with the top of the row and the
+// height of its tallest tile.
+type gridRow struct {
+	cells  []gridCell
+	top    int
+	height int
+}
+
+// gridPlan is where every grid tile goes on a canvas of size, with padding
+// between tiles and margin around them for rotated tiles to turn in.
+type gridPlan struct {
+	rows    []gridRow
+	size    image.Point
+	padding int
+	margin  int
+}
+
+func (o Options) planGrid(images []image.Image) gridPlan {
+	numberOfRows := o.rows
+	footer := o.footerHeight()
+
+	imagesMatrix := o.packRows(images)
+	maxNumberOfColumns := 0
+	for _, row := range imagesMatrix {
+		if len(row) > maxNumberOfColumns {
+			maxNumberOfColumns = len(row)
+		}
 	}
 
 	maxWidth := uint(0)
+	margin := 0
 	imagesSize := make([][]Size, numberOfRows)
 	for row := 0; row < numberOfRows; row++ {
 		imagesSize[row] = make([]Size, len(imagesMatrix[row]))
 
-		calculatedWidth := math.Floor(float64(desiredWidth) / float64(len(imagesMatrix[row])))
+		widths := o.cellWidths(imagesMatrix[row])
 
 		rowWidth := uint(0)
 		rowHeight := uint(0)
 		for col := 0; col < len(imagesMatrix[row]); col++ {
-			originalWidth := float64(Width(imagesMatrix[row][col]))
-			originalHeight := float64(Height(imagesMatrix[row][col]))
-			resizeFactor := calculatedWidth / originalWidth
-
-			w := uint(originalWidth * resizeFactor)
-			h := uint(originalHeight * resizeFactor)
-			imagesSize[row][col] = Size{w, h}
+			shape := o.tileShape(imagesMatrix[row][col])
+			size := o.tileSize(imagesMatrix[row][col], widths[col])
+			w, h := size.width, size.height
+			imagesSize[row][col] = size
+
+			if angle := o.rotations[imagesMatrix[row][col]]; angle != 0 && shape == RectangleShape {
+				rw, rh := rotatedSize(int(w), int(h), angle)
+				if m := (rw - int(w) + 1) / 2; m > margin {
+					margin = m
+				}
+				if m := (rh - int(h) + 1) / 2; m > margin {
+					margin = m
+				}
+			}
 
 			if shape == RectangleShape {
 				rowWidth += w
@@ -163,115 +448,365 @@ func makeImageCollage(desiredWidth int, desiredHeight int, numberOfRows int, sha
 		}
 	}
 
+	// Each row is as high as its tallest tile, as drawn below; summing
+	// columns instead would clip rows whose tallest tiles are in different
+	// columns.
 	maxHeight := uint(0)
-	for col := 0; col < maxNumberOfColumns; col++ {
-		colHeight := uint(0)
-		for row := 0; row < numberOfRows; row++ {
-			if len(imagesSize[row]) > col {
-				if shape == RectangleShape {
-					colHeight += imagesSize[row][col].height
-				} else {
-					colHeight += uint(math.Min(float64(imagesSize[row][col].height), float64(imagesSize[row][col].width)) * CircleDiameter)
-				}
+	for row := 0; row < numberOfRows; row++ {
+		rowHeight := uint(0)
+		for col, size := range imagesSize[row] {
+			h := size.height
+			if o.tileShape(imagesMatrix[row][col]) != RectangleShape {
+				h = uint(math.Min(float64(size.height), float64(size.width)) * CircleDiameter)
+			}
+			if h > rowHeight {
+				rowHeight = h
 			}
 		}
-
-		if colHeight > maxHeight {
-			maxHeight = colHeight
-		}
+		maxHeight += rowHeight + uint(footer)
 	}
 
-	padding := 1
+	padding := o.tilePadding()
+	plan := gridPlan{
+		size:    image.Point{int(maxWidth) + (maxNumberOfColumns-1)*padding + 2*padding + 2*margin, int(maxHeight) + (numberOfRows-1)*padding + 2*padding + 2*margin},
+		padding: padding,
+		margin:  margin,
+	}
 
-	if shape == CircleShape {
-		padding = 20
+	sp_y := padding + margin
+	for row := 0; row < numberOfRows; row++ {
+		r := gridRow{top: sp_y}
+		widths := o.cellWidths(imagesMatrix[row])
+		sp_x := padding + margin
+		for col, img := range imagesMatrix[row] {
+			size := imagesSize[row][col]
+			if o.tileShape(img) != RectangleShape {
+				d := uint(math.Min(float64(size.width), float64(size.height)) * CircleDiameter)
+				size = Size{d, d}
+			}
+			r.cells = append(r.cells, gridCell{img, widths[col], size, image.Point{sp_x, sp_y}})
+			sp_x += int(size.width) + padding
+			if int(size.height) > r.height {
+				r.height = int(size.height)
+			}
+		}
+		plan.rows = append(plan.rows, r)
+		sp_y += r.height + footer + padding
 	}
+	return plan
+}
 
-	rectangleEnd := image.Point{int(maxWidth) + (maxNumberOfColumns-1)*padding + 2*padding, int(maxHeight) + (numberOfRows-1)*padding + 2*padding}
+func makeImageCollage(opts Options, images ...image.Image) *MyImage {
+	g := drawGrid(opts, images)
+	g.scaleTiles()
+	return g.composite(nil)
+}
 
-	output := MyImage{image.NewRGBA(image.Rectangle{image.ZP, rectangleEnd})}
+// tileDraw is one draw of a grid collage and the image it belongs to, nil
+// for separators.
+type tileDraw struct {
+	img  image.Image
+	draw func(dst *MyImage)
+}
 
-	sp_x, sp_y := 0, 0
-	for row := 0; row < numberOfRows; row++ {
-		rowHeight := uint(0)
+// gridDrawing is a grid collage placed but not yet drawn: the work to
+// scale every tile and the draws that put the tiles on the canvas, then
+// their captions and scale bars.
+type gridDrawing struct {
+	opts     Options
+	plan     gridPlan
+	images   []image.Image
+	scale    []func()
+	draws    []tileDraw
+	overlays []tileDraw
+}
 
-		calculatedWidth := math.Floor(float64(desiredWidth) / float64(len(imagesMatrix[row])))
-		for col := 0; col < len(imagesMatrix[row]); col++ {
-			resizeFactor := float64(1)
-			originalWidth := float64(Width(imagesMatrix[row][col]))
-			resizeFactor = calculatedWidth / originalWidth
+// drawGrid places every tile, recording the work to scale it and the draws
+// that put it on the canvas.
+func drawGrid(opts Options, images []image.Image) gridDrawing {
+	plan := opts.planGrid(images)
+	footer := opts.footerHeight()
+	padding, margin := plan.padding, plan.margin
+
+	var scale []func()
+	var draws []tileDraw
+	var overlays []tileDraw
+	for row, planned := range plan.rows {
+		var gutters []int
+
+		for col, cell := range planned.cells {
+			img := cell.img
+			shape := opts.tileShape(img)
+			mode, opacity := opts.tileBlend(img)
+			calculatedWidth := cell.width
+			w, h := cell.size.width, cell.size.height
+			sp := cell.at
+			var tile image.Image
+
+			if angle := opts.rotations[img]; angle != 0 && shape == RectangleShape {
+				// Border the tile before rotating so the frame turns with it.
+				scale = append(scale, func() {
+					framed := MyImage{image.NewRGBA64(image.Rect(0, 0, int(w), int(h)))}
+					framed.drawRaw(opts.vignetteTile(opts.scaleTile(img, calculatedWidth, w, h), shape), image.ZP)
+					framed.drawTileBorder(opts.styles[img], shape, framed.Bounds())
+					tile = rotateImage(framed.value, angle)
+				})
+				draws = append(draws, tileDraw{img, func(dst *MyImage) {
+					at := sp.Add(image.Point{(int(w) - Width(tile)) / 2, (int(h) - Height(tile)) / 2})
+					blendDraw(dst, tile.Bounds().Add(at), tile, image.ZP, nil, image.ZP, mode, opacity)
+				}})
+			} else if shape == RectangleShape {
+				scale = append(scale, func() {
+					tile = opts.vignetteTile(opts.scaleTile(img, calculatedWidth, w, h), shape)
+				})
+				draws = append(draws, tileDraw{img, func(dst *MyImage) {
+					if mode == BlendNormal && opacity >= 1 {
+						dst.drawRaw(tile, sp)
+					} else {
+						blendDraw(dst, tile.Bounds().Sub(tile.Bounds().Min).Add(sp), tile, tile.Bounds().Min, nil, image.ZP, mode, opacity)
+					}
+					dst.drawTileBorder(opts.styles[img], shape, image.Rect(sp.X, sp.Y, sp.X+int(w), sp.Y+int(h)))
+				}})
+			} else {
+				scale = append(scale, func() {
+					tile = opts.vignetteTile(opts.scaleTile(img, calculatedWidth, w, h), shape)
+				})
+				draws = append(draws, tileDraw{img, func(dst *MyImage) {
+					dst.drawInCircle(tile, sp, int(w), mode, opacity)
+					dst.drawTileBorder(opts.styles[img], shape, image.Rect(sp.X, sp.Y, sp.X+int(w), sp.Y+int(h)))
+				}})
+			}
 
-			w := uint(originalWidth * resizeFactor)
-			h := uint(float64(Height(imagesMatrix[row][col])) * resizeFactor)
+			if opts.placements != nil {
+				opts.placements[img] = image.Rect(sp.X, sp.Y, sp.X+int(w), sp.Y+int(h))
+			}
 
-			if col == 0 {
-				sp_x = padding
+			footerTop := sp.Y + int(h)
+			if opts.scaleBars {
+				lo, hi := intensityRange(img)
+				at, width := image.Point{sp.X, footerTop}, int(w)
+				overlays = append(overlays, tileDraw{img, func(dst *MyImage) {
+					dst.drawScaleBar(at, width, lo, hi)
+				}})
+				footerTop += scaleBarHeight
+			}
+			if caption, ok := opts.captions[img]; ok {
+				at, width := image.Point{sp.X, footerTop}, int(w)
+				overlays = append(overlays, tileDraw{img, func(dst *MyImage) {
+					dst.drawCaption(opts, caption, at, width)
+				}})
 			}
 
-			if row == 0 {
-				sp_y = padding
+			if col < len(planned.cells)-1 {
+				gutters = append(gutters, sp.X+int(w))
 			}
+		}
 
-			sp := image.Point{sp_x, sp_y}
+		// Separators are centered in the gutters, vertical ones as tall as
+		// the row and horizontal ones across the whole collage.
+		sep := opts.separators
+		rowTop, rowBottom := planned.top, planned.top+planned.height+footer
+		if sep.vertical {
+			draws = append(draws, tileDraw{nil, func(dst *MyImage) {
+				for _, x := range gutters {
+					drawLine(dst, image.Point{x + (padding-sep.width)/2, rowTop}, rowBottom-rowTop, sep.width, true, sep.color)
+				}
+			}})
+		}
+		if sep.horizontal && row < len(plan.rows)-1 {
+			draws = append(draws, tileDraw{nil, func(dst *MyImage) {
+				drawLine(dst, image.Point{padding + margin, rowBottom + (padding-sep.width)/2}, plan.size.X-2*(padding+margin), sep.width, false, sep.color)
+			}})
+		}
+	}
 
-			if shape == RectangleShape {
-				output.drawRaw(imagesMatrix[row][col], sp, w, h)
-			} else {
-				w = uint(math.Min(float64(w), float64(h)) * CircleDiameter)
-				h = w
+	return gridDrawing{opts, plan, images, scale, draws, overlays}
+}
 
-				output.drawInCircle(imagesMatrix[row][col], sp, w, h, int(w))
-			}
+// scaleTiles scales every tile, in parallel.
+func (g gridDrawing) scaleTiles() {
+	defer stats.enter("resize")()
+	parallel(len(g.scale), largestDecoded(g.images), func(i int) { g.scale[i]() })
+}
 
-			sp_x += int(w) + padding
+// composite draws the scaled tiles for which shown is true, or all of them
+// when shown is nil, with the draws they came with. The canvas is
+// composited in bands, each replaying the draws in order; captions and
+// scale bars are rasterized once, serially, afterwards: their font faces
+// are not safe for concurrent use.
+func (g gridDrawing) composite(shown func(img image.Image) bool) *MyImage {
+	defer stats.enter("composite")()
+	var draws []func(dst *MyImage)
+	for _, d := range g.draws {
+		if d.img == nil || shown == nil || shown(d.img) {
+			draws = append(draws, d.draw)
+		}
+	}
+	output := g.opts.newCanvas(image.Rectangle{image.ZP, g.plan.size})
+	compositeBands(&output, draws)
+	for _, d := range g.overlays {
+		if shown == nil || shown(d.img) {
+			d.draw(&output)
+		}
+	}
+	return &output
+}
 
-			if h > rowHeight {
-				rowHeight = h
-			}
+func decodeImage(data []byte) (image.Image, error) {
+	if bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		return decodeJPEG(data)
+	}
+	if isAnimated(data) {
+		return Options{}.decodeFrame(data, FrameFirst)
+	}
+	if isSVG("", data) {
+		return decodeSVG(data)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
 
+func (o Options) loadImage(path string) (image.Image, error) {
+	defer stats.enter("decode")()
+	if _, _, _, ok := splitVideoPath(path); ok {
+		img, err := extractFrame(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return img, nil
+	}
+	if _, _, ok := splitPDFPath(path); ok {
+		img, err := renderPDFPage(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
 		}
+		return img, nil
+	}
 
-		sp_x = 0
-		sp_y += int(rowHeight) + padding
+	if img, ok := takeExpandedFrame(path); ok {
+		return img, nil
+	}
+	file, frame := splitFramePath(path)
+	data, err := readInput(file)
+	if err != nil {
+		return nil, err
+	}
 
+	var img image.Image
+	if isRaw(file) {
+		img, err = decodeRaw(file, data, o.raw)
+	} else if isSVG(file, data) {
+		img, err = decodeSVG(data)
+	} else if err = limits.checkDecode(data); err == nil {
+		if isAnimated(data) {
+			img, err = o.decodeFrame(data, frame)
+		} else {
+			img, err = decodeImage(data)
+		}
 	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return img, nil
+}
 
-	return &output
+// needsInputDir reports whether expanding paths writes files to
+// opts.inputDir, as stdin, remote and archive inputs do.
+func needsInputDir(paths []string) bool {
+	for _, path := range paths {
+		if path == "-" || isRemote(path) || isArchivePath(path) {
+			return true
+		}
+	}
+	return false
 }
 
-func main() {
-	if len(os.Args) < 3 {
-		log.Fatal("No shape or number of rows defined")
-	} else {
-		imageShape := ImageShape(os.Args[1])
-		numberOfRows, errNr := strconv.Atoi(os.Args[2])
+// expandInputs reads "-" from stdin and downloads remote inputs, then turns
+// each zip or tar archive into the images in it, each PDF into its pages,
+// each video into opts.videoFrames frames and, with -frame all, each
+// animation into its frames, leaving ordinary image paths as they are.
+func (o Options) expandInputs(paths []string) ([]string, error) {
+	paths, err := readStdin(paths, o.inputDir)
+	if err != nil {
+		return nil, err
+	}
+	if paths, err = fetchRemote(paths, o.inputDir); err != nil {
+		return nil, err
+	}
+	if paths, err = expandArchives(paths, o.inputDir); err != nil {
+		return nil, err
+	}
+	if paths, err = expandPDFs(paths); err != nil {
+		return nil, err
+	}
+	if paths, err = expandFrames(paths, o.frame); err != nil {
+		return nil, err
+	}
+	return expandVideos(paths, o.videoFrames)
+}
 
-		if errNr == nil && (imageShape == RectangleShape || imageShape == CircleShape) {
-			images := make([]image.Image, len(os.Args)-3)
+func loadImages(opts Options, paths []string) []image.Image {
+	images := make([]image.Image, 0, len(paths))
+	broken := make(map[int]string)
+	for i, path := range paths {
+		img, err := opts.loadImage(path)
+		if err != nil {
+			if !opts.lenient {
+				logger.Fatal(err)
+			}
+			logger.Warnf("%v; drawing a placeholder", err)
+			broken[i] = path
+			continue
+		}
 
-			for i := 3; i < len(os.Args); i++ {
-				fimg, _ := os.Open(os.Args[i])
-				defer fimg.Close()
-				img, _, _ := image.Decode(fimg)
+		logger.Debugf("Loaded %s (%dx%d)", path, Width(img), Height(img))
+		images = append(images, limits.shrinkDecoded(img, len(paths), opts.width, opts.integerScale))
+	}
 
-				images[i-3] = img
-			}
+	images = normalizeTiles(images, opts.normalize, opts.deep)
+	for i, img := range images {
+		images[i] = opts.filterTile(img)
+	}
 
-			output := makeImageCollage(800, 800, numberOfRows, imageShape, images...)
-			imview.Show(output.value)
-		} else {
-			log.Fatal("No shape or number of rows defined")
+	// Placeholders go in after normalizing and filtering so they stay
+	// recognizable.
+	if len(broken) > 0 {
+		all := make([]image.Image, len(paths))
+		for i, j := 0, 0; i < len(paths); i++ {
+			if path, ok := broken[i]; ok {
+				all[i] = brokenTile(path)
+			} else {
+				all[i], j = images[j], j+1
+			}
 		}
+		images = all
 	}
+	return images
+}
 
-	// output := MyImage{image.NewRGBA(image.Rectangle{image.ZP, image.Point{400, 400}})}
+type groupList []string
 
-	// fimg, _ := os.Open("dog.jpg")
-	// defer fimg.Close()
-	// img, _, _ := image.Decode(fimg)
-	// output.drawRaw(img, image.Point{100, 100}, 180, 150)
-	// // output.drawInCircle(img, image.Point{100, 100}, 180, 180, 150)
+func (g *groupList) String() string {
+	return strings.Join(*g, " ")
+}
 
-	// imview.Show(output.value)
+func (g *groupList) Set(value string) error {
+	*g = append(*g, value)
+	return nil
+}
 
+// groupCollages renders each comma-separated group of paths into a
+// rectangle sub-collage with roughly as many rows as columns. The results
+// are ordinary tiles for any layout that takes a list of images.
+func groupCollages(opts Options, groups []string) []image.Image {
+	collages := make([]image.Image, len(groups))
+	for i, group := range groups {
+		paths, err := opts.expandInputs(strings.Split(group, ","))
+		if err != nil {
+			logger.Fatal(err)
+		}
+		rows := int(math.Max(1, math.Round(math.Sqrt(float64(len(paths))))))
+		collages[i] = makeImageCollage(Options{width: opts.width, height: opts.height, rows: rows, shape: RectangleShape}, loadImages(opts, paths)...)
+	}
+	return collages
 }
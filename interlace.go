@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// adam7 lists the x/y offset and step of the seven interlacing passes.
+var adam7 = [7][4]int{
+	{0, 0, 8, 8}, {4, 0, 8, 8}, {0, 4, 4, 8}, {2, 0, 4, 4}, {0, 2, 2, 4}, {1, 0, 2, 2}, {0, 1, 1, 2},
+}
+
+// encodeInterlacedPNG writes img as an Adam7-interlaced PNG, which
+// image/png cannot produce. Opaque images are stored as RGB, and 16-bit
+// images keep 16 bits per channel.
+func encodeInterlacedPNG(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	deep := false
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		deep = true
+	}
+	opaque := false
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+
+	// Work from straight alpha RGBA, 8 bytes a pixel when deep.
+	var pix []byte
+	var stride int
+	if deep {
+		n := image.NewNRGBA64(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(n, n.Rect, img, b.Min, draw.Src)
+		pix, stride = n.Pix, n.Stride
+	} else {
+		n := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(n, n.Rect, img, b.Min, draw.Src)
+		pix, stride = n.Pix, n.Stride
+	}
+	src, channels, depth, colorType := 4, 4, 8, byte(6)
+	if deep {
+		src, depth = 8, 16
+	}
+	if opaque {
+		channels, colorType = 3, 2
+	}
+	bpp := channels * depth / 8
+
+	var idat bytes.Buffer
+	zw := zlib.NewWriter(&idat)
+	for _, pass := range adam7 {
+		pw := (b.Dx() - pass[0] + pass[2] - 1) / pass[2]
+		ph := (b.Dy() - pass[1] + pass[3] - 1) / pass[3]
+		if pw <= 0 || ph <= 0 {
+			continue
+		}
+		prev, cur := make([]byte, pw*bpp), make([]byte, pw*bpp)
+		for y := pass[1]; y < b.Dy(); y += pass[3] {
+			i := 0
+			for x := pass[0]; x < b.Dx(); x += pass[2] {
+				p := pix[y*stride+x*src:]
+				i += copy(cur[i:], p[:bpp])
+			}
+			if _, err := zw.Write(filterRow(cur, prev, bpp)); err != nil {
+				return err
+			}
+			prev, cur = cur, prev
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(b.Dy()))
+	ihdr[8], ihdr[9], ihdr[12] = byte(depth), colorType, 1
+	if _, err := w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return err
+	}
+	for _, chunk := range []struct {
+		name string
+		data []byte
+	}{{"IHDR", ihdr}, {"IDAT", idat.Bytes()}, {"IEND", nil}} {
+		if err := writeChunk(w, chunk.name, chunk.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeChunk(w io.Writer, name string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+	for _, p := range [][]byte{header, data, footer} {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterRow returns the row prefixed with its filter type, choosing the
+// filter with the smallest sum of absolute differences as image/png does.
+func filterRow(cur []byte, prev []byte, bpp int) []byte {
+	var best []byte
+	bestSum := -1
+	for f := byte(0); f < 5; f++ {
+		out := make([]byte, 1+len(cur))
+		out[0] = f
+		sum := 0
+		for i, v := range cur {
+			// a is the byte to the left, b the one above, c above left.
+			a, b, c := 0, int(prev[i]), 0
+			if i >= bpp {
+				a, c = int(cur[i-bpp]), int(prev[i-bpp])
+			}
+			var pred int
+			switch f {
+			case 1:
+				pred = a
+			case 2:
+				pred = b
+			case 3:
+				pred = (a + b) / 2
+			case 4:
+				pred = paeth(a, b, c)
+			}
+			d := v - byte(pred)
+			out[1+i] = d
+			if d < 128 {
+				sum += int(d)
+			} else {
+				sum += 256 - int(d)
+			}
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = out, sum
+		}
+	}
+	return best
+}
+
+func paeth(a int, b int, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// progressiveJPEG losslessly rewrites baseline JPEG data as progressive
+// with jpegtran from libjpeg(-turbo), since image/jpeg only writes
+// baseline.
+func progressiveJPEG(data []byte) ([]byte, error) {
+	p, err := exec.LookPath("jpegtran")
+	if err != nil {
+		return nil, errors.New("jpeg: jpegtran not found in PATH; install libjpeg-turbo for progressive output")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(p, "-progressive", "-optimize", "-copy", "none")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New("jpeg: " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	return out, nil
+}
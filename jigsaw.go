@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Knob geometry as fractions of the shorter cell side: the knob radius,
+// and how far its center sits past the edge it crosses.
+const (
+	knobRadius = 0.2
+	knobOffset = 0.11
+)
+
+// jigsawEdge is the boundary between two neighboring pieces: the grid
+// line from a to b and a round knob from owner through its middle.
+type jigsawEdge struct {
+	a, b   [2]float64
+	center [2]float64
+	owner  int
+	other  int
+}
+
+// parseJigsawGrid reads ROWSxCOLS such as 3x4.
+func parseJigsawGrid(s string) (int, int, error) {
+	var rows, cols int
+	if _, err := fmt.Sscanf(s, "%dx%d", &rows, &cols); err != nil || rows < 1 || cols < 1 {
+		return 0, 0, fmt.Errorf("invalid jigsaw grid %q, expected ROWSxCOLS such as 3x4", s)
+	}
+	return rows, cols, nil
+}
+
+// jigsawCells cuts the canvas into rows x cols interlocking pieces. Every
+// inside edge has a knob, and the piece it sticks out of alternates from
+// edge to edge so each piece mixes tabs and blanks.
+func jigsawCells(width int, height int, rows int, cols int) *MosaicCells {
+	cw, ch := float64(width)/float64(cols), float64(height)/float64(rows)
+	r := knobRadius * math.Min(cw, ch)
+	off := knobOffset * math.Min(cw, ch)
+
+	// right[i] and below[i] are the edges right of and below piece i.
+	right := make([]*jigsawEdge, rows*cols)
+	below := make([]*jigsawEdge, rows*cols)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			i := row*cols + col
+			if col < cols-1 {
+				x, y := float64(col+1)*cw, (float64(row)+0.5)*ch
+				e := &jigsawEdge{a: [2]float64{x, float64(row) * ch}, b: [2]float64{x, float64(row+1) * ch}, owner: i, other: i + 1}
+				dir := 1.0
+				if (row+col)%2 == 1 {
+					e.owner, e.other, dir = i+1, i, -1
+				}
+				e.center = [2]float64{x + dir*off, y}
+				right[i] = e
+			}
+			if row < rows-1 {
+				x, y := (float64(col)+0.5)*cw, float64(row+1)*ch
+				e := &jigsawEdge{a: [2]float64{float64(col) * cw, y}, b: [2]float64{float64(col+1) * cw, y}, owner: i, other: i + cols}
+				dir := 1.0
+				if (row+col)%2 == 0 {
+					e.owner, e.other, dir = i+cols, i, -1
+				}
+				e.center = [2]float64{x, y + dir*off}
+				below[i] = e
+			}
+		}
+	}
+
+	cells := newMosaicCells(width, height, rows*cols)
+	parallel(height, 0, func(y int) {
+		row := int(math.Min(float64(rows-1), float64(y)/ch))
+		for x := 0; x < width; x++ {
+			col := int(math.Min(float64(cols-1), float64(x)/cw))
+			i := row*cols + col
+			var edges []*jigsawEdge
+			if col > 0 {
+				edges = append(edges, right[i-1])
+			}
+			if col < cols-1 {
+				edges = append(edges, right[i])
+			}
+			if row > 0 {
+				edges = append(edges, below[i-cols])
+			}
+			if row < rows-1 {
+				edges = append(edges, below[i])
+			}
+
+			p := [2]float64{float64(x) + 0.5, float64(y) + 0.5}
+			label, edge := i, math.MaxFloat64
+			for _, e := range edges {
+				if e.other == i && math.Hypot(p[0]-e.center[0], p[1]-e.center[1]) < r {
+					label = e.owner
+				}
+				edge = math.Min(edge, e.distance(p, r))
+			}
+			cells.labels[y*width+x] = int32(label)
+			cells.edge[y*width+x] = float32(edge)
+		}
+	})
+	return cells
+}
+
+// distance is how far p is from the edge's outline: the grid line outside
+// the knob's neck and the arc of the knob past the line.
+func (e *jigsawEdge) distance(p [2]float64, r float64) float64 {
+	// Work in the edge's frame: u along the line, v across it toward the
+	// knob, with the line at v = 0.
+	ux, uy := e.b[0]-e.a[0], e.b[1]-e.a[1]
+	length := math.Hypot(ux, uy)
+	ux, uy = ux/length, uy/length
+	vx, vy := -uy, ux
+	if (e.center[0]-e.a[0])*vx+(e.center[1]-e.a[1])*vy < 0 {
+		vx, vy = -vx, -vy
+	}
+	u := (p[0]-e.a[0])*ux + (p[1]-e.a[1])*uy
+	v := (p[0]-e.a[0])*vx + (p[1]-e.a[1])*vy
+	cu := (e.center[0]-e.a[0])*ux + (e.center[1]-e.a[1])*uy
+	cv := (e.center[0]-e.a[0])*vx + (e.center[1]-e.a[1])*vy
+	neck := math.Sqrt(r*r - cv*cv)
+
+	// The line from the ends to the neck on both sides.
+	d := math.Inf(1)
+	for _, seg := range [2][2]float64{{0, cu - neck}, {cu + neck, length}} {
+		t := math.Max(seg[0], math.Min(seg[1], u))
+		d = math.Min(d, math.Hypot(u-t, v))
+	}
+	// The arc beyond the line: when p's nearest point on the circle is
+	// on the near side, the arc's nearest point is a neck end instead,
+	// which the segments already reach.
+	if h := math.Hypot(u-cu, v-cv); h == 0 {
+		d = math.Min(d, r)
+	} else if cv+r*(v-cv)/h >= 0 {
+		d = math.Min(d, math.Abs(h-r))
+	}
+	return d
+}
+
+// drawCellOutlines strokes the edges between mosaic cells width pixels
+// wide, antialiased.
+func drawCellOutlines(canvas *MyImage, cells *MosaicCells, width float64, c color.Color) {
+	if width <= 0 {
+		return
+	}
+	mask := image.NewAlpha(cells.rect)
+	for i, e := range cells.edge {
+		mask.Pix[i] = uint8(255 * clamp01(width/2-float64(e)+0.5))
+	}
+	draw.DrawMask(canvas.value, cells.rect, image.NewUniform(c), image.ZP, mask, image.ZP, draw.Over)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"strings"
+)
+
+// adobeCMYKMarker is an Adobe APP14 segment with transform 0, which tells
+// image/jpeg that four-component data is plain CMYK.
+var adobeCMYKMarker = []byte{
+	0xff, 0xee, 0x00, 0x0e,
+	'A', 'd', 'o', 'b', 'e',
+	0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// decodeJPEG decodes baseline, progressive and CMYK/YCCK JPEGs and always
+// returns an RGB image. image/jpeg rejects CMYK files that lack the Adobe
+// APP14 marker; those are written by non-Adobe tools without Adobe's
+// inverted ink values, so they are decoded with a synthetic marker and the
+// channels flipped back.
+func decodeJPEG(data []byte) (image.Image, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	inverted := false
+	if _, ok := err.(jpeg.UnsupportedError); ok && strings.Contains(err.Error(), "APP14") {
+		patched := make([]byte, 0, len(data)+len(adobeCMYKMarker))
+		patched = append(patched, data[:2]...)
+		patched = append(patched, adobeCMYKMarker...)
+		patched = append(patched, data[2:]...)
+		img, err = jpeg.Decode(bytes.NewReader(patched))
+		inverted = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cmyk, ok := img.(*image.CMYK)
+	if !ok {
+		return img, nil
+	}
+	if inverted {
+		for i := range cmyk.Pix {
+			cmyk.Pix[i] = 255 - cmyk.Pix[i]
+		}
+	}
+
+	rgba := image.NewRGBA(cmyk.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), cmyk, cmyk.Bounds().Min, draw.Src)
+	return rgba, nil
+}
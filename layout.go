@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/duffiye/imagecollager/layout"
+)
+
+func init() {
+	layout.Register("grid", gridLayout{})
+	layout.Register("row", rowLayout{})
+	layout.Register("masonry", masonryLayout{})
+}
+
+// layoutOptions hands opts to a registered layout, whole in Extra for the
+// built-in layouts, which need more of it than the common fields.
+func (o Options) layoutOptions() layout.Options {
+	return layout.Options{
+		Width:   o.width,
+		Height:  o.height,
+		Rows:    o.rows,
+		Columns: o.columns,
+		Padding: o.tilePadding(),
+		Shape:   layout.Shape(o.shape),
+		Weights: o.weights,
+		Extra:   o,
+	}
+}
+
+// optionsOf recovers the options layoutOptions passed, or builds them from
+// the common fields when a layout is planned from elsewhere.
+func optionsOf(lo layout.Options) Options {
+	if o, ok := lo.Extra.(Options); ok {
+		return o
+	}
+	return Options{
+		width:      lo.Width,
+		height:     lo.Height,
+		rows:       lo.Rows,
+		columns:    lo.Columns,
+		padding:    lo.Padding,
+		paddingSet: true,
+		shape:      ImageShape(lo.Shape),
+		weights:    lo.Weights,
+	}
+}
+
+func isRegisteredLayout(name string) bool {
+	_, ok := layout.Lookup(name)
+	return ok
+}
+
+// renderLayout draws images with the layout registered under name: by its
+// own Render when it has one, from its placements otherwise.
+func renderLayout(opts Options, name string, images []image.Image) (*MyImage, error) {
+	l, ok := layout.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown layout %q", name)
+	}
+	if r, ok := l.(layout.Renderer); ok {
+		img := r.Render(images, opts.layoutOptions())
+		if img == nil {
+			return nil, fmt.Errorf("the %s layout cannot place %d images with these options", name, len(images))
+		}
+		return &MyImage{img}, nil
+	}
+	placements := l.Plan(images, opts.layoutOptions())
+	if len(placements) == 0 {
+		return nil, fmt.Errorf("the %s layout cannot place %d images with these options", name, len(images))
+	}
+	return drawPlacements(opts, placements), nil
+}
+
+// drawPlacements scales every placed image to its rectangle, cropping it to
+// cover the rectangle when the aspect ratios differ, and draws it with its
+// style, vignette and blending.
+func drawPlacements(opts Options, placements []layout.Placement) *MyImage {
+	var bounds image.Rectangle
+	for i, p := range placements {
+		if i == 0 {
+			bounds = p.Rect
+		} else {
+			bounds = bounds.Union(p.Rect)
+		}
+	}
+	output := opts.newCanvas(image.Rect(0, 0, bounds.Max.X+bounds.Min.X, bounds.Max.Y+bounds.Min.Y))
+
+	images := make([]image.Image, len(placements))
+	for i, p := range placements {
+		images[i] = p.Image
+	}
+	tiles := make([]image.Image, len(placements))
+	parallel(len(placements), largestDecoded(images), func(i int) {
+		p := placements[i]
+		w, h := p.Rect.Dx(), p.Rect.Dy()
+		if ImageShape(p.Shape) == CircleShape {
+			w = int(math.Min(float64(w), float64(h)))
+			h = w
+		}
+		if w < 1 || h < 1 {
+			return
+		}
+		// A rectangle of the image's own aspect ratio, to the pixel, is
+		// scaled into as the grid does; any other is covered.
+		var tile image.Image
+		if d := float64(w*Height(p.Image) - h*Width(p.Image)); math.Abs(d) <= math.Max(float64(Width(p.Image)), float64(Height(p.Image))) {
+			tile = resample(p.Image, uint(w), uint(h))
+		} else {
+			tile = coverTile(p.Image, w, h)
+		}
+		tiles[i] = opts.vignetteTile(tile, ImageShape(p.Shape))
+	})
+
+	for i, tile := range tiles {
+		if tile == nil {
+			continue
+		}
+		p := placements[i]
+		mode, opacity := opts.tileBlend(p.Image)
+		at := p.Rect.Min.Add(image.Pt((p.Rect.Dx()-Width(tile))/2, (p.Rect.Dy()-Height(tile))/2))
+		r := image.Rectangle{at, at.Add(image.Pt(Width(tile), Height(tile)))}
+		if ImageShape(p.Shape) == CircleShape {
+			output.drawInCircle(tile, at, Width(tile), mode, opacity)
+		} else if mode == BlendNormal && opacity >= 1 {
+			output.drawRaw(tile, at)
+		} else {
+			blendDraw(&output, r, tile, tile.Bounds().Min, nil, image.ZP, mode, opacity)
+		}
+		output.drawTileBorder(opts.styles[p.Image], ImageShape(p.Shape), r)
+		if opts.placements != nil {
+			opts.placements[p.Image] = r
+		}
+	}
+	return &output
+}
+
+// gridLayout is the grid of opts.rows rows. Its plan leaves out the
+// captions, rotations and separators Render draws.
+type gridLayout struct{}
+
+func (gridLayout) Plan(images []image.Image, lo layout.Options) []layout.Placement {
+	opts := optionsOf(lo)
+	if validateRows(opts.rows, len(images)) != nil {
+		return nil
+	}
+	var placements []layout.Placement
+	for _, row := range opts.planGrid(images).rows {
+		for _, c := range row.cells {
+			r := image.Rect(c.at.X, c.at.Y, c.at.X+int(c.size.width), c.at.Y+int(c.size.height))
+			placements = append(placements, layout.Placement{Image: c.img, Rect: r, Shape: layout.Shape(opts.tileShape(c.img))})
+		}
+	}
+	return placements
+}
+
+func (gridLayout) Render(images []image.Image, lo layout.Options) draw.Image {
+	opts := optionsOf(lo)
+	if validateRows(opts.rows, len(images)) != nil {
+		return nil
+	}
+	return makeImageCollage(opts, images...).value
+}
+
+// rowLayout lays images edge to edge in one row, each scaled to exactly
+// opts.Height pixels high.
+type rowLayout struct{}
+
+func (rowLayout) Plan(images []image.Image, opts layout.Options) []layout.Placement {
+	placements := make([]layout.Placement, len(images))
+	x := 0
+	for i, img := range images {
+		// The width the resize package gives an image scaled by height.
+		w := int(0.7 + float64(Width(img))*float64(opts.Height)/float64(Height(img)))
+		placements[i] = layout.Placement{Image: img, Rect: image.Rect(x, 0, x+w, opts.Height), Shape: layout.Rectangle}
+		x += w
+	}
+	return placements
+}
+
+// masonryLayout fills opts.Columns columns of equal width across
+// opts.Width pixels, about the square root of the image count without a
+// count, each image in turn going to the bottom of the shortest column.
+type masonryLayout struct{}
+
+func (masonryLayout) Plan(images []image.Image, opts layout.Options) []layout.Placement {
+	columns := opts.Columns
+	if columns < 1 {
+		columns = int(math.Round(math.Sqrt(float64(len(images)))))
+	}
+	if columns > len(images) {
+		columns = len(images)
+	}
+	if columns < 1 {
+		return nil
+	}
+	padding := opts.Padding
+	width := float64(opts.Width-(columns+1)*padding) / float64(columns)
+	if width < 1 {
+		width = 1
+	}
+
+	bottoms := make([]int, columns)
+	for i := range bottoms {
+		bottoms[i] = padding
+	}
+	placements := make([]layout.Placement, len(images))
+	for i, img := range images {
+		col := 0
+		for c := range bottoms {
+			if bottoms[c] < bottoms[col] {
+				col = c
+			}
+		}
+		x0 := padding + int(math.Round(float64(col)*(width+float64(padding))))
+		x1 := padding + int(math.Round(float64(col)*(width+float64(padding))+width))
+		h := int(math.Round(float64(x1-x0) * float64(Height(img)) / float64(Width(img))))
+		placements[i] = layout.Placement{Image: img, Rect: image.Rect(x0, bottoms[col], x1, bottoms[col]+h), Shape: layout.Rectangle}
+		bottoms[col] += h + padding
+	}
+	return placements
+}
@@ -0,0 +1,107 @@
+// Package layout is the registry of imagecollager's collage layouts. A
+// layout decides where every image of a collage goes; imagecollager scales
+// and draws the tiles. Other packages add layouts by registering them from
+// an init function and are linked in with a blank import:
+//
+//	import _ "example.com/spiral"
+package layout
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"sort"
+	"sync"
+)
+
+// Shape is the outline a tile is cut to.
+type Shape string
+
+const (
+	Rectangle Shape = "Rectangle"
+	// Circle fills the largest square centered in its rectangle.
+	Circle Shape = "Circle"
+)
+
+// Placement is where a layout puts one image: the rectangle its tile fills
+// on the canvas and the shape it is cut to.
+type Placement struct {
+	Image image.Image
+	Rect  image.Rectangle
+	Shape Shape
+}
+
+// Options are the settings a layout plans from. Fields a layout has no use
+// for may be ignored.
+type Options struct {
+	// Width and Height are the canvas size the collage aims for.
+	Width  int
+	Height int
+	// Rows and Columns are the counts asked for, zero when not given.
+	Rows    int
+	Columns int
+	// Padding is the gap between neighboring tiles.
+	Padding int
+	Shape   Shape
+	// Weights sizes images relative to each other, 1 for images without.
+	Weights map[image.Image]float64
+
+	// Extra carries the settings of the program a layout runs in, for
+	// layouts that program registers itself.
+	Extra interface{}
+}
+
+// Layout plans a collage. Placements lie below and right of the origin,
+// and the canvas spans them with the margin above and left of them
+// repeated below and right. Plan returns no placements for options it
+// cannot lay the images out with, such as more rows than images.
+type Layout interface {
+	Plan(images []image.Image, opts Options) []Placement
+}
+
+// Renderer is implemented by layouts that draw more than their tiles, such
+// as captions or dividers. Render draws the whole collage in place of the
+// tiles of Plan, or returns nil when Plan would return no placements.
+type Renderer interface {
+	Layout
+	Render(images []image.Image, opts Options) draw.Image
+}
+
+var registry = struct {
+	sync.Mutex
+	m map[string]Layout
+}{m: make(map[string]Layout)}
+
+// Register makes a layout available under name. Registering a nil layout
+// or a name twice panics.
+func Register(name string, l Layout) {
+	registry.Lock()
+	defer registry.Unlock()
+	if l == nil {
+		panic("layout: Register of a nil layout")
+	}
+	if _, dup := registry.m[name]; dup {
+		panic(fmt.Sprintf("layout: Register called twice for %q", name))
+	}
+	registry.m[name] = l
+}
+
+// Lookup returns the layout registered under name.
+func Lookup(name string) (Layout, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	l, ok := registry.m[name]
+	return l, ok
+}
+
+// Names lists the registered layouts in order.
+func Names() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	names := make([]string, 0, len(registry.m))
+	for name := range registry.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Limits bounds the memory and goroutines a run may use. The zero value
+// is unlimited, with one worker per CPU.
+type Limits struct {
+	memory  int64
+	workers int
+}
+
+var limits Limits
+
+// parseByteSize accepts a byte count with an optional K, M or G suffix in
+// powers of 1024, as in 512M or 1.5GiB.
+func parseByteSize(s string) (int64, error) {
+	t := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	unit := 1.0
+	if n := len(t); n > 0 {
+		switch t[n-1] {
+		case 'K':
+			unit, t = 1<<10, t[:n-1]
+		case 'M':
+			unit, t = 1<<20, t[:n-1]
+		case 'G':
+			unit, t = 1<<30, t[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(t, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size %q; use bytes or a K, M or G suffix such as 512M", s)
+	}
+	return int64(v * unit), nil
+}
+
+// apply makes the memory limit the garbage collector's soft limit, so it
+// collects harder as the process approaches it.
+func (l Limits) apply() {
+	if l.memory > 0 {
+		debug.SetMemoryLimit(l.memory)
+	}
+}
+
+// workerCount is how many goroutines may share n tasks that each need
+// about scratch bytes: one per CPU at most, fewer under -max-workers, and
+// as many as fit in a quarter of the memory limit, down to serial.
+func (l Limits) workerCount(n int, scratch int64) int {
+	workers := runtime.GOMAXPROCS(0)
+	if l.workers > 0 && l.workers < workers {
+		workers = l.workers
+	}
+	if l.memory > 0 && scratch > 0 {
+		if fit := int(l.memory / 4 / scratch); fit < workers {
+			workers = fit
+		}
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+func decodedSize(img image.Image) int64 {
+	bpp := int64(4)
+	if isDeep(img) {
+		bpp = 8
+	}
+	return int64(Width(img)) * int64(Height(img)) * bpp
+}
+
+// largestDecoded is the decoded size of the largest image, about the
+// scratch memory scaling one of them takes.
+func largestDecoded(images []image.Image) int64 {
+	largest := int64(0)
+	for _, img := range images {
+		if s := decodedSize(img); s > largest {
+			largest = s
+		}
+	}
+	return largest
+}
+
+// checkDecode refuses to decode data whose pixels alone would take more
+// than the memory limit, before the decoder allocates them.
+func (l Limits) checkDecode(data []byte) error {
+	if l.memory <= 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// Leave the error to the decoder, which says more.
+		return nil
+	}
+	bpp := int64(4)
+	switch cfg.ColorModel {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		bpp = 8
+	}
+	if size := int64(cfg.Width) * int64(cfg.Height) * bpp; size > l.memory {
+		return fmt.Errorf("decoding %dx%d pixels takes %d MiB, more than -max-memory allows", cfg.Width, cfg.Height, size>>20)
+	}
+	return nil
+}
+
+// rangedImage keeps the physical value range of an image through a
+// shrink that returns a plain raster.
+type rangedImage struct {
+	image.Image
+	lo, hi float64
+}
+
+func (r rangedImage) ValueRange() (float64, float64) {
+	return r.lo, r.hi
+}
+
+// shrinkDecoded scales a freshly decoded image down when keeping it whole
+// would take more than its share, half the memory limit over count
+// images, but never below minWidth, which no tile is wider than. Exact
+// pixel modes shrink by whole factors only.
+func (l Limits) shrinkDecoded(img image.Image, count int, minWidth int, integerScale bool) image.Image {
+	if l.memory <= 0 || count < 1 {
+		return img
+	}
+	if _, ok := img.(vectorImage); ok {
+		return img
+	}
+	share := l.memory / 2 / int64(count)
+	size := decodedSize(img)
+	if size <= share || Width(img) <= minWidth {
+		return img
+	}
+	scale := math.Max(math.Sqrt(float64(share)/float64(size)), float64(minWidth)/float64(Width(img)))
+	var shrunk image.Image
+	if integerScale {
+		k := int(1 / scale)
+		if k < 2 {
+			return img
+		}
+		logger.Debugf("Shrinking a %dx%d image by %d to fit -max-memory", Width(img), Height(img), k)
+		shrunk = integerDownscale(img, k)
+	} else {
+		w := uint(math.Max(1, math.Round(float64(Width(img))*scale)))
+		logger.Debugf("Shrinking a %dx%d image to %d wide to fit -max-memory", Width(img), Height(img), w)
+		shrunk = resample(img, w, 0)
+	}
+	if r, ok := img.(valueRanger); ok {
+		lo, hi := r.ValueRange()
+		return rangedImage{shrunk, lo, hi}
+	}
+	return shrunk
+}
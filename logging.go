@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogLevel orders log messages from errors, always shown, to debugging
+// detail shown only with -v.
+type LogLevel int
+
+const (
+	LevelError LogLevel = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+func (l LogLevel) String() string {
+	return [...]string{"error", "warn", "info", "debug"}[l]
+}
+
+// Logger writes messages at or below its level, as lines in the standard
+// log format or, for automation, as one JSON object per line.
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level LogLevel
+	json  bool
+	// cleanups run before Fatal exits, which skips deferred calls.
+	cleanups []func()
+}
+
+var logger = &Logger{out: os.Stderr, level: LevelInfo}
+
+func (l *Logger) logf(level LogLevel, format string, v ...interface{}) {
+	if level > l.level {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json {
+		line, _ := json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{now.Format(time.RFC3339), level.String(), msg})
+		l.out.Write(append(line, '\n'))
+		return
+	}
+	fmt.Fprintf(l.out, "%s %s\n", now.Format("2006/01/02 15:04:05"), msg)
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(LevelError, format, v...) }
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(LevelDebug, format, v...) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.logf(LevelInfo, format, v...) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.logf(LevelWarn, format, v...) }
+
+// Fatal and Fatalf log an error and exit with status 1, like log.Fatal,
+// after running the cleanups registered with onFatal.
+func (l *Logger) Fatal(v ...interface{}) {
+	l.logf(LevelError, "%s", fmt.Sprint(v...))
+	l.exit()
+}
+
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.logf(LevelError, format, v...)
+	l.exit()
+}
+
+// onFatal registers f to run when Fatal exits, such as removing the
+// temporary files a deferred call would.
+func (l *Logger) onFatal(f func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleanups = append(l.cleanups, f)
+}
+
+func (l *Logger) exit() {
+	l.mu.Lock()
+	cleanups := l.cleanups
+	l.cleanups = nil
+	l.mu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	os.Exit(1)
+}
+
+// addLogFlags registers -v, -q and -log-format on fs. The returned func
+// configures the logger from them once fs is parsed.
+func addLogFlags(fs *flag.FlagSet) func() {
+	verbose := fs.Bool("v", false, "log debugging detail")
+	quiet := fs.Bool("q", false, "log errors only")
+	format := fs.String("log-format", "text", "log format: text or json (one object per line)")
+	return func() {
+		switch *format {
+		case "text":
+		case "json":
+			logger.json = true
+		default:
+			logger.Fatalf("Unknown log format %q", *format)
+		}
+		switch {
+		case *verbose && *quiet:
+			logger.Fatal("Cannot log both verbosely and quietly")
+		case *verbose:
+			logger.level = LevelDebug
+		case *quiet:
+			logger.level = LevelError
+		}
+	}
+}
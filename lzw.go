@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// TIFF LZW codes: after the 256 literals come Clear and EndOfInformation.
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwMaxWidth = 12
+)
+
+// compressTIFFLZW compresses data the way TIFF's LZW expects: codes are
+// packed most significant bit first and widen one code earlier than in GIF,
+// and the table starts over with a Clear code before it overflows 12 bits.
+func compressTIFFLZW(data []byte) []byte {
+	var out []byte
+	var bits uint32
+	var nbits uint
+	width := uint(9)
+	emit := func(code int) {
+		bits |= uint32(code) << (32 - width - nbits)
+		nbits += width
+		for nbits >= 8 {
+			out = append(out, byte(bits>>24))
+			bits <<= 8
+			nbits -= 8
+		}
+	}
+
+	table := make(map[uint32]int)
+	next := lzwEOI + 1
+	emit(lzwClear)
+	if len(data) == 0 {
+		emit(lzwEOI)
+	} else {
+		code := int(data[0])
+		for _, b := range data[1:] {
+			key := uint32(code)<<8 | uint32(b)
+			if c, ok := table[key]; ok {
+				code = c
+				continue
+			}
+			emit(code)
+			table[key] = next
+			next++
+			code = int(b)
+			if next >= 1<<width {
+				if width == lzwMaxWidth {
+					emit(lzwClear)
+					table = make(map[uint32]int)
+					next, width = lzwEOI+1, 9
+				} else {
+					width++
+				}
+			}
+		}
+		emit(code)
+		// The decoder widens after reading this code too, so the end code
+		// may need the wider width.
+		if next++; next >= 1<<width && width < lzwMaxWidth {
+			width++
+		}
+		emit(lzwEOI)
+	}
+	if nbits > 0 {
+		out = append(out, byte(bits>>24))
+	}
+	return out
+}
+
+// compressTIFF rewrites the uncompressed single-strip TIFF the standard
+// encoder writes with the strip LZW compressed. The IFD follows the strip,
+// so it moves with its values and their offsets shift by the bytes saved.
+func compressTIFF(data []byte) ([]byte, error) {
+	const (
+		tagCompression     = 259
+		tagStripByteCounts = 279
+		compressionLZW     = 5
+	)
+	if len(data) < 8 || string(data[:4]) != "II*\x00" {
+		return nil, errors.New("tiff: not a little-endian TIFF")
+	}
+	order := binary.LittleEndian
+	ifd := int(order.Uint32(data[4:]))
+	if ifd < 8 || ifd+2 > len(data) {
+		return nil, errors.New("tiff: bad IFD offset")
+	}
+	n := int(order.Uint16(data[ifd:]))
+	if ifd+2+12*n+4 > len(data) {
+		return nil, errors.New("tiff: truncated IFD")
+	}
+
+	strip := compressTIFFLZW(data[8:ifd])
+	size := len(strip)
+	if size%2 == 1 {
+		strip = append(strip, 0)
+	}
+	shift := len(strip) - (ifd - 8)
+	out := make([]byte, 0, len(data)+shift)
+	out = append(out, data[:8]...)
+	out = append(out, strip...)
+	out = append(out, data[ifd:]...)
+	order.PutUint32(out[4:], uint32(ifd+shift))
+
+	at := ifd + shift
+	for i := 0; i < n; i++ {
+		entry := out[at+2+12*i:]
+		typ, count := order.Uint16(entry[2:]), order.Uint32(entry[4:])
+		switch order.Uint16(entry) {
+		case tagCompression:
+			order.PutUint16(entry[8:], compressionLZW)
+		case tagStripByteCounts:
+			order.PutUint32(entry[8:], uint32(size))
+		default:
+			if unit, ok := exifTypeSizes[typ]; ok && unit*count > 4 {
+				order.PutUint32(entry[8:], uint32(int(order.Uint32(entry[8:]))+shift))
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,811 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"flag"
+	"image"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fogleman/imview"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "split":
+			splitMain(os.Args[2:])
+			return
+		case "ui":
+			uiMain(os.Args[2:])
+			return
+		case "album":
+			albumMain(os.Args[2:])
+			return
+		case "atlas":
+			atlasMain(os.Args[2:])
+			return
+		case "rerender":
+			rerenderMain(os.Args[2:])
+			return
+		case "daemon":
+			daemonMain(os.Args[2:])
+			return
+		}
+	}
+
+	layout := flag.String("layout", "grid", "layout: grid, calendar, circles, compare, confusion, dataset, filmstrip, mask, masonry, regression, row, text, treemap, triangles, variants, voronoi or jigsaw")
+	tolerance := flag.Int("tolerance", 0, "per-channel difference (0-255) the regression layout ignores")
+	maxDiff := flag.Float64("max-diff", 0, "fraction of differing pixels a regression case may have and still pass")
+	pairBy := flag.String("pair", "suffix", "how the compare layout groups images: suffix (name_before, name_after) or order")
+	compareN := flag.Int("compare-n", 2, "images per row when the compare layout pairs by order")
+	divider := flag.Bool("divider", false, "draw a dividing line between compared images")
+	labels := flag.Bool("labels", false, "label compared images with their variant or file name")
+	outputPath := flag.String("o", "", "write the collage to this .png, .jpg, .tif or .bmp file, an .mp4 or .webm video (see -video-style), s3://, gs:// or az:// object, or - for stdout, instead of showing it")
+	format := flag.String("format", "", "output format overriding the -o extension: png, jpg, tif or bmp (default png for -o -)")
+	tiffCompression := flag.String("tiff-compression", "none", "compression of TIFF output: none, lzw or deflate")
+	preview := flag.String("preview", "window", "show the collage in a window, or inline in the terminal: term (auto-detect), sixel, iterm, kitty or ansi")
+	stdinList := flag.Bool("stdin-list", false, "read further image paths from stdin, one per line; a - argument instead reads a zip/tar stream or one image")
+	live := flag.Bool("live", false, "decode the images once and serve a web UI that re-renders as options change")
+	liveAddr := flag.String("live-addr", "localhost:8080", "address the -live UI is served on")
+	videoStyle := flag.String("video-style", "assemble", "animation of a grid collage written to an .mp4 or .webm -o file with ffmpeg: assemble (tiles fade in one by one) or shuffle (cross-fades between shuffled arrangements)")
+	videoStep := flag.Float64("video-step", 0, "seconds each tile takes to fade in with -video-style assemble, or each arrangement is shown with shuffle (default 0.4 and 2)")
+	rowSpec := flag.String("row-spec", "", "tiles in each grid row, top to bottom, such as 1,3,3,2 for a header image over smaller rows; images keep their order and the rows argument must match")
+	pack := flag.String("pack", "index", "how grid rows are filled: index (tallest first, equal rows), greedy (first-fit-decreasing by aspect ratio against an even row width) or balanced (row lengths chosen for the least whitespace)")
+	separators := flag.String("separators", "", "divider lines in the grid gutters: horizontal, vertical or both")
+	separatorWidth := flag.Int("separator-width", 2, "thickness of -separators lines in pixels; gutters widen to fit")
+	separatorColor := flag.String("separator-color", "#ffffff", "color of -separators lines as #rrggbb")
+	border := flag.Int("border", 0, "width in pixels of a frame drawn around the whole collage")
+	borderColor := flag.String("border-color", "#ffffff", "color of the -border frame as #rrggbb")
+	cornerRadius := flag.Int("corner-radius", 0, "round the collage corners to this radius in pixels, transparent outside")
+	manifestPath := flag.String("manifest", "", "write a JSON manifest of where each grid tile went and the hash of its source, for the rerender subcommand")
+	stylePath := flag.String("style", "", "JSON file of per-tile grid styles keyed by file name or 1-based position: {\"hero.jpg\": {\"shape\", \"border\", \"borderColor\", \"rotate\", \"caption\", \"scale\", \"blend\", \"opacity\"}}; the treemap and circles layouts read \"weight\"")
+	blend := flag.String("blend", "normal", "how overlapping tiles mix: normal, multiply, screen, lighten or darken")
+	opacity := flag.Float64("opacity", 1, "opacity of every tile, 0-1")
+	placeholder := flag.String("placeholder", "", "complete the last grid row with placeholder tiles: a #rrggbb color, blur (blurred copies of the images) or an image file such as a logo")
+	clampRows := flag.Bool("clamp-rows", false, "lower a grid row count above the number of images to that number, with a warning, instead of failing")
+	edit := flag.Bool("edit", false, "arrange grid tiles by dragging them in a window before saving with -o (the default when there is no -o)")
+	clipboard := flag.Bool("clipboard", false, "copy the collage to the system clipboard instead of showing it")
+	dataset := flag.String("dataset", "", "CSV with a header row and path, label and score columns for the dataset layout")
+	labelField := flag.String("label-field", "label", "dataset column to group tiles by")
+	scoreField := flag.String("score-field", "score", "dataset column shown as the tile caption")
+	rowField := flag.String("row-field", "label", "dataset column for the rows of the confusion layout")
+	colField := flag.String("col-field", "predicted", "dataset column for the columns of the confusion layout")
+	cellSamples := flag.Int("cell-samples", 4, "sample images per cell in the confusion layout")
+	columns := flag.Int("columns", 8, "tiles per row in the dataset layout, or columns of the masonry layout")
+	pageSize := flag.Int("page-size", 0, "maximum tiles per dataset page, 0 for a single page")
+	var groups groupList
+	flag.Var(&groups, "group", "comma-separated images rendered as one sub-collage tile (repeatable)")
+	rotate := flag.String("rotate", "", "rotate rectangle grid tiles: an angle in degrees, a per-image list a,b,c, or a random range min:max")
+	seed := flag.Int64("seed", 1, "seed for random rotation angles and voronoi cells")
+	classBorders := flag.Bool("class-borders", false, "draw a colored border per class in the dataset layout")
+	text := flag.String("text", "", "text whose glyphs the text layout fills with photos")
+	fontPath := flag.String("font", "", "TrueType/OpenType font for the text layout (default: Go Bold)")
+	variantSep := flag.String("variant-sep", "_", "separator between base name and variant suffix for the variants and compare layouts")
+	month := flag.String("month", "", "calendar month as YYYY-MM (default: month of the earliest photo)")
+	maskPath := flag.String("mask", "", "silhouette image whose opaque region the mask layout fills")
+	jigsawGrid := flag.String("jigsaw-grid", "", "pieces of the jigsaw layout as ROWSxCOLS (default about square for the image count); images repeat to fill them")
+	outline := flag.Float64("outline", 0, "width in pixels of the lines drawn around jigsaw, voronoi and triangles cells")
+	outlineColor := flag.String("outline-color", "#202020", "color of the -outline lines as #rrggbb")
+	cellGap := flag.Float64("cell-gap", 2, "gap in pixels between the cells of the voronoi, triangles, treemap and circles layouts")
+	rowHeight := flag.Int("height", 400, "height every image is scaled to in the row layout; the width follows")
+	stripSize := flag.Int("strip-size", 160, "tile height of the filmstrip layout, or tile width with -strip-vertical")
+	stripVertical := flag.Bool("strip-vertical", false, "run the filmstrip top to bottom instead of left to right")
+	sprockets := flag.Bool("sprockets", false, "punch sprocket holes along the filmstrip edges")
+	captions := flag.String("captions", "", "caption preset under each grid tile: exif (video frames show their timestamp by default)")
+	captionAlign := flag.String("caption-align", "left", "caption alignment: left, center or right")
+	captionFont := flag.String("caption-font", "basic", "caption font: basic, regular or mono")
+	captionSize := flag.Float64("caption-size", 12, "caption font size in points for the regular and mono fonts")
+	emptyColor := flag.String("empty-color", "", "fill color for empty calendar days as #rrggbb (default: blank)")
+	filterEffect := flag.String("filter-effect", "", "color effect applied to every tile: grayscale, sepia or tint:#rrggbb")
+	normalize := flag.String("normalize", "", "even out tile exposure: mean (match mean luminance) or levels (auto-levels each tile)")
+	vignetteStrength := flag.Float64("vignette", 0, "darken each tile toward its edge by this amount (0-1)")
+	vignetteFalloff := flag.Float64("vignette-falloff", 2, "vignette curve exponent; higher keeps more of the tile center bright")
+	depth := flag.Int("depth", 8, "bits per channel of the rendered collage: 8, or 16 for 16-bit PNG/TIFF output")
+	raw := flag.String("raw", "auto", "camera raw handling: decode (dcraw/libraw), preview (embedded JPEG) or auto")
+	science := flag.Bool("science", false, "scientific contact sheet: integer downscaling and intensity scale bars")
+	frame := flag.String("frame", "first", "frame of animated GIF/WebP inputs: first, middle, last, N, or all for one tile per frame")
+	videoFrames := flag.Int("video-frames", 9, "frames taken at even intervals from each video input")
+	progressive := flag.Bool("progressive", false, "write progressive JPEG (needs jpegtran from libjpeg-turbo)")
+	interlace := flag.Bool("interlace", false, "write Adam7-interlaced PNG")
+	printSize := flag.String("print-size", "", "lay out for printing on paper: A3-A6, Letter, Legal, Tabloid, 4x6, 5x7, 8x10 or WxH in mm, cm or in (e.g. 100x150mm)")
+	preset := flag.String("preset", "", "size the collage for a social platform: instagram-square (1080x1080), instagram-story (1080x1920), twitter-header (1500x500) or og-image (1200x630), with a safe margin and the platform's format")
+	safeTop := flag.Int("safe-top", 0, "pixels at the top of the -preset or -print-size page kept clear of tiles and captions, where platform overlays sit (instagram-story keeps 250)")
+	safeBottom := flag.Int("safe-bottom", 0, "pixels at the bottom of the -preset or -print-size page kept clear of tiles and captions (instagram-story keeps 250)")
+	landscape := flag.Bool("landscape", false, "turn the -print-size paper sideways")
+	dpi := flag.Int("dpi", 0, "print resolution written into the output file (default 300 with -print-size)")
+	noMetadata := flag.Bool("no-metadata", false, "do not embed the tool version, flags and source file hashes in the output as XMP (PNG, JPEG and TIFF)")
+	strict := flag.Bool("strict", true, "exit when an input fails to load; -strict=false draws a labeled placeholder tile for it instead")
+	integerScale := flag.Bool("integer-scale", false, "downscale by whole-number factors only, preserving exact pixel values")
+	maxMemory := flag.String("max-memory", "", "memory budget such as 512M or 2G: large inputs are shrunk as they are decoded and fewer tiles are scaled at once")
+	maxWorkers := flag.Int("max-workers", 0, "most goroutines scaling and compositing tiles at once (default one per CPU)")
+	showStats := flag.Bool("stats", false, "report the time spent decoding, resizing, laying out, compositing and encoding, and the peak memory use")
+	configureLogging := addLogFlags(flag.CommandLine)
+	flag.Parse()
+	configureLogging()
+	if *showStats {
+		stats.enable()
+	}
+	if *maxWorkers < 0 {
+		logger.Fatal("Max workers must not be negative")
+	}
+	limits.workers = *maxWorkers
+	if *maxMemory != "" {
+		var err error
+		if limits.memory, err = parseByteSize(*maxMemory); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	limits.apply()
+	args := flag.Args()
+
+	opts := Options{
+		width:        800,
+		height:       800,
+		integerScale: *integerScale || *science,
+		scaleBars:    *science,
+
+		vignette:        *vignetteStrength,
+		vignetteFalloff: *vignetteFalloff,
+
+		deep:    *depth == 16,
+		lenient: !*strict,
+	}
+	if *depth != 8 && *depth != 16 {
+		logger.Fatal("Depth must be 8 or 16")
+	}
+	if *vignetteStrength < 0 || *vignetteStrength > 1 {
+		logger.Fatal("Vignette must be between 0 and 1")
+	}
+	if *vignetteFalloff <= 0 {
+		logger.Fatal("Vignette falloff must be positive")
+	}
+	if *dpi < 0 {
+		logger.Fatal("DPI must be positive")
+	}
+	opts.encoding.dpi = *dpi
+	opts.encoding.progressive, opts.encoding.interlace = *progressive, *interlace
+	if *safeTop < 0 || *safeBottom < 0 {
+		logger.Fatal("Safe zones must not be negative")
+	}
+	opts.safeTop, opts.safeBottom = *safeTop, *safeBottom
+	if *printSize != "" {
+		paper, err := parsePaperSize(*printSize)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if *landscape {
+			paper = paper.landscape()
+		}
+		if opts.encoding.dpi == 0 {
+			opts.encoding.dpi = 300
+		}
+		opts.paper.X, opts.paper.Y = paper.pixels(opts.encoding.dpi)
+		opts.width, opts.height = opts.paper.X, opts.paper.Y-opts.safeTop-opts.safeBottom
+	}
+	opts.raw = RawMode(*raw)
+	if opts.raw != RawAuto && opts.raw != RawDecode && opts.raw != RawPreview {
+		logger.Fatalf("Unknown raw mode %q", *raw)
+	}
+	face, err := captionFace(*captionFont, *captionSize)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	opts.captionFace = face
+	if *videoFrames < 1 {
+		logger.Fatal("Video frames must be at least 1")
+	}
+	opts.videoFrames = *videoFrames
+	opts.frame, err = parseFrameSelection(*frame)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if *stdinList {
+		for _, arg := range args {
+			if arg == "-" {
+				logger.Fatal("Stdin cannot hold both a -stdin-list and a - image or archive")
+			}
+		}
+		list, err := readPathList(os.Stdin)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		args = append(args, list...)
+	}
+	inputs := append([]string(nil), args...)
+	for _, group := range groups {
+		inputs = append(inputs, strings.Split(group, ",")...)
+	}
+	if needsInputDir(inputs) {
+		opts.inputDir, err = ioutil.TempDir("", "imagecollager-")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		dir := opts.inputDir
+		logger.onFatal(func() { os.RemoveAll(dir) })
+		defer os.RemoveAll(dir)
+	}
+	if !*noMetadata {
+		if opts.encoding.metadata, err = newProvenance(flag.CommandLine, *layout, args); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	// Expand the arguments one at a time so each image keeps the weight
+	// of the argument it came from. Only the layouts sizing tiles by weight
+	// read a ":N" suffix; elsewhere it stays part of the path.
+	weighted := *layout == "treemap" || *layout == "circles"
+	var weights []float64
+	var expanded []string
+	for _, arg := range args {
+		path, w := arg, 1.0
+		if weighted {
+			path, w = splitWeight(arg)
+		}
+		paths, err := opts.expandInputs([]string{path})
+		if err != nil {
+			logger.Fatal(err)
+		}
+		for range paths {
+			weights = append(weights, w)
+		}
+		expanded = append(expanded, paths...)
+	}
+	args = expanded
+	opts.filter, err = parseFilter(*filterEffect)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	opts.normalize, err = parseNormalization(*normalize)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	opts.pack, err = parsePackStrategy(*pack)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	sepColor, err := parseHexColor(*separatorColor)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	opts.separators, err = parseSeparators(*separators, *separatorWidth, sepColor)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	opts.blend, err = parseBlendMode(*blend)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if *opacity <= 0 || *opacity > 1 {
+		logger.Fatal("Opacity must be above 0 and at most 1")
+	}
+	opts.opacity = *opacity
+	if *border < 0 || *cornerRadius < 0 {
+		logger.Fatal("Border and corner radius must not be negative")
+	}
+	opts.border.width, opts.border.radius = *border, *cornerRadius
+	opts.border.color, err = parseHexColor(*borderColor)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	opts.captionAlign = CaptionAlign(*captionAlign)
+	if opts.captionAlign != AlignLeft && opts.captionAlign != AlignCenter && opts.captionAlign != AlignRight {
+		logger.Fatalf("Unknown caption alignment %q", *captionAlign)
+	}
+
+	switch PreviewMode(*preview) {
+	case PreviewWindow, PreviewTerm, PreviewSixel, PreviewITerm, PreviewKitty, PreviewANSI:
+	default:
+		logger.Fatalf("Unknown preview mode %q", *preview)
+	}
+	if *outputPath == "-" && PreviewMode(*preview) != PreviewWindow {
+		logger.Fatal("Cannot preview in the terminal while writing the collage to stdout")
+	}
+	switch *format {
+	case "":
+	case "png", "jpg", "jpeg", "tif", "tiff", "bmp":
+		opts.encoding.format = "." + *format
+	default:
+		logger.Fatalf("Unknown output format %q", *format)
+	}
+	switch *tiffCompression {
+	case "none", "lzw", "deflate":
+		opts.encoding.compression = *tiffCompression
+	default:
+		logger.Fatalf("Unknown TIFF compression %q", *tiffCompression)
+	}
+	if *preset != "" {
+		if *printSize != "" {
+			logger.Fatal("Cannot use a preset and a print size together")
+		}
+		p, err := parsePreset(*preset)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		opts = p.apply(opts, *outputPath)
+	}
+	if (opts.safeTop > 0 || opts.safeBottom > 0) && opts.paper == (image.Point{}) {
+		logger.Fatal("Safe zones need a -preset or -print-size page")
+	}
+	if opts.height < 1 {
+		logger.Fatal("The safe zones leave no room on the page")
+	}
+
+	if *emptyColor != "" {
+		c, err := parseHexColor(*emptyColor)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		opts.emptyColor = c
+	}
+
+	if *live {
+		options := map[string]string{"layout": *layout, "filter": *filterEffect, "normalize": *normalize,
+			"rotate": *rotate, "seed": strconv.FormatInt(*seed, 10), "pack": *pack}
+		if *text != "" {
+			options["text"] = *text
+		}
+		paths := args
+		switch *layout {
+		case "grid":
+			if len(args) < 2 {
+				logger.Fatal("No shape or number of rows defined")
+			}
+			options["shape"], options["rows"] = args[0], args[1]
+			paths = args[2:]
+		case "text", "compare":
+		default:
+			logger.Fatalf("Live mode supports the grid, text and compare layouts, not %q", *layout)
+		}
+		liveMain(*liveAddr, opts, paths, options)
+		return
+	}
+
+	if isVideoOutput(*outputPath) && (*layout != "grid" || isRemote(*outputPath)) {
+		logger.Fatal("Video output needs the grid layout and a local -o file")
+	}
+
+	var output *MyImage
+	var pages []*MyImage
+	var manifest *Manifest
+	failed := false
+	viewed := false
+	doneLayout := stats.enter("layout")
+	logger.Debugf("Rendering the %s layout from %d inputs", *layout, len(args))
+	switch *layout {
+	case "calendar":
+		if len(args) == 0 {
+			logger.Fatal("No images defined")
+		}
+
+		images := loadImages(opts, args)
+		photos := make([]DatedImage, len(images))
+		for i, img := range images {
+			date, err := captureTime(args[i])
+			if err != nil {
+				logger.Fatal(err)
+			}
+			photos[i] = DatedImage{img, date}
+		}
+
+		var m time.Time
+		if *month != "" {
+			var err error
+			m, err = time.ParseInLocation("2006-01", *month, time.Local)
+			if err != nil {
+				logger.Fatalf("Invalid month %q, expected YYYY-MM", *month)
+			}
+		} else {
+			m = earliestDate(photos)
+		}
+		output = makeCalendarCollage(opts, m, photos)
+	case "dataset", "confusion":
+		if *dataset == "" {
+			logger.Fatal("No dataset CSV defined")
+		}
+		if *columns < 1 || *cellSamples < 1 {
+			logger.Fatal("Number of columns and cell samples must be at least 1")
+		}
+
+		records, err := readDataset(*dataset)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		dopts := DatasetOptions{
+			labelField:   *labelField,
+			scoreField:   *scoreField,
+			columns:      *columns,
+			pageSize:     *pageSize,
+			classBorders: *classBorders,
+		}
+		if *layout == "confusion" {
+			output, err = makeConfusionSheet(opts, dopts, *rowField, *colField, *cellSamples, records)
+		} else {
+			pages, err = makeDatasetSheets(opts, dopts, records)
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+	case "mask":
+		if *maskPath == "" {
+			logger.Fatal("No mask image defined")
+		}
+
+		shape, err := opts.loadImage(*maskPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		output = makeMaskCollage(opts, shape, append(loadImages(opts, args), groupCollages(opts, groups)...)...)
+	case "text":
+		if *text == "" {
+			logger.Fatal("No text defined")
+		}
+
+		f, err := loadFont(*fontPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		shape, err := textMask(*text, f, opts.width)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		output = makeMaskCollage(opts, shape, append(loadImages(opts, args), groupCollages(opts, groups)...)...)
+	case "compare":
+		if len(args) == 0 {
+			logger.Fatal("No images defined")
+		}
+
+		images := loadImages(opts, args)
+		var rows [][]LabeledImage
+		switch *pairBy {
+		case "suffix":
+			rows = pairBySuffix(args, images, *variantSep)
+		case "order":
+			if *compareN < 1 {
+				logger.Fatal("Number of compared images must be at least 1")
+			}
+			rows = pairByOrder(args, images, *compareN)
+		default:
+			logger.Fatalf("Unknown pairing %q", *pairBy)
+		}
+		output = makeComparisonSheet(opts, rows, *divider, *labels)
+	case "regression":
+		if len(args) == 0 {
+			logger.Fatal("No images defined")
+		}
+
+		results := runRegression(regressionCases(args, loadImages(Options{}, args), *variantSep), *tolerance, *maxDiff)
+		output = makeRegressionReport(opts, results)
+		for _, res := range results {
+			if !res.passed {
+				failed = true
+			}
+		}
+	case "filmstrip":
+		if len(args) == 0 && len(groups) == 0 {
+			logger.Fatal("No images defined")
+		}
+		if *stripSize < 1 {
+			logger.Fatal("Strip size must be at least 1")
+		}
+
+		output = makeFilmstrip(opts, append(loadImages(opts, args), groupCollages(opts, groups)...), *stripSize, *stripVertical, *sprockets)
+	case "voronoi", "triangles", "jigsaw":
+		images := append(loadImages(opts, args), groupCollages(opts, groups)...)
+		if len(images) == 0 {
+			logger.Fatal("No images defined")
+		}
+		if *cellGap < 0 {
+			logger.Fatal("Cell gap must not be negative")
+		}
+
+		c, err := parseHexColor(*outlineColor)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		gap := *cellGap
+		var cells *MosaicCells
+		switch *layout {
+		case "voronoi":
+			cells = voronoiCells(opts.width, opts.height, len(images), rand.New(rand.NewSource(*seed)))
+		case "triangles":
+			cells = triangleCells(opts.width, opts.height, len(images))
+		case "jigsaw":
+			cols := int(math.Ceil(math.Sqrt(float64(len(images)) * float64(opts.width) / float64(opts.height))))
+			rows := (len(images) + cols - 1) / cols
+			if *jigsawGrid != "" {
+				if rows, cols, err = parseJigsawGrid(*jigsawGrid); err != nil {
+					logger.Fatal(err)
+				}
+			}
+			for i := len(images); i < rows*cols; i++ {
+				images = append(images, images[i%len(images)])
+			}
+			cells = jigsawCells(opts.width, opts.height, rows, cols)
+			opts.keepOrder, gap = true, 0
+		}
+		output = makeMosaic(opts, cells, images[:cells.count], gap)
+		drawCellOutlines(output, cells, *outline, c)
+	case "treemap", "circles":
+		if len(args) == 0 && len(groups) == 0 {
+			logger.Fatal("No images defined")
+		}
+		if *cellGap < 0 {
+			logger.Fatal("Cell gap must not be negative")
+		}
+
+		images := loadImages(opts, args)
+		opts.weights = make(map[image.Image]float64)
+		for i, img := range images {
+			if weights[i] != 1 {
+				opts.weights[img] = weights[i]
+			}
+		}
+		if *stylePath != "" {
+			styles, err := readTileStyles(*stylePath, args, images)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			for img, s := range styles {
+				if s.Weight > 0 {
+					opts.weights[img] = s.Weight
+				}
+			}
+		}
+		images = append(images, groupCollages(opts, groups)...)
+		if *layout == "treemap" {
+			output = makeTreemap(opts, images, int(math.Round(*cellGap)))
+		} else {
+			output = makeCirclePack(opts, images, *cellGap)
+		}
+	case "row":
+		if len(args) == 0 && len(groups) == 0 {
+			logger.Fatal("No images defined")
+		}
+		if *rowHeight < 1 {
+			logger.Fatal("Height must be at least 1")
+		}
+
+		opts.height = *rowHeight
+		var err error
+		if output, err = renderLayout(opts, "row", append(loadImages(opts, args), groupCollages(opts, groups)...)); err != nil {
+			logger.Fatal(err)
+		}
+	case "variants":
+		if len(args) == 0 {
+			logger.Fatal("No images defined")
+		}
+
+		groups, variants := groupVariants(args, loadImages(opts, args), *variantSep)
+		output = makeVariantsSheet(opts, groups, variants)
+	case "grid":
+		if len(args) < 2 {
+			logger.Fatal("No shape or number of rows defined")
+		}
+
+		imageShape := ImageShape(args[0])
+		numberOfRows, errNr := strconv.Atoi(args[1])
+		if errNr != nil || (imageShape != RectangleShape && imageShape != CircleShape) {
+			logger.Fatal("No shape or number of rows defined")
+		}
+
+		opts.shape = imageShape
+		images := loadImages(opts, args[2:])
+		sources := make(map[image.Image]string)
+		for i, img := range images {
+			sources[img] = args[2+i]
+		}
+		switch *captions {
+		case "":
+			for i, img := range images {
+				if caption := videoCaption(args[2+i]); caption != nil {
+					if opts.captions == nil {
+						opts.captions = make(map[image.Image][]string)
+					}
+					opts.captions[img] = caption
+				}
+			}
+		case "exif":
+			opts.captions = make(map[image.Image][]string)
+			for i, img := range images {
+				opts.captions[img] = exifCaption(args[2+i])
+			}
+		default:
+			logger.Fatalf("Unknown caption preset %q", *captions)
+		}
+		images = append(images, groupCollages(opts, groups)...)
+		fill, err := opts.parsePlaceholder(*placeholder)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		missing := 0
+		if *rowSpec != "" {
+			if opts.rowSpec, err = parseRowSpec(*rowSpec); err != nil {
+				logger.Fatal(err)
+			}
+			if numberOfRows != len(opts.rowSpec) {
+				logger.Fatalf("The row spec has %d rows, not %d", len(opts.rowSpec), numberOfRows)
+			}
+			total := 0
+			for _, n := range opts.rowSpec {
+				total += n
+			}
+			// Placeholders may complete a spec the images fall short of.
+			if total < len(images) || total > len(images) && fill == nil {
+				logger.Fatalf("The row spec holds %d tiles, not %d", total, len(images))
+			}
+			missing = total - len(images)
+		} else {
+			if *clampRows && numberOfRows > len(images) && len(images) > 0 {
+				logger.Warnf("Clamping %d rows to the %d images", numberOfRows, len(images))
+				numberOfRows = len(images)
+			}
+			if err := validateRows(numberOfRows, len(images)); err != nil {
+				logger.Fatal(err)
+			}
+			missing = (numberOfRows - len(images)%numberOfRows) % numberOfRows
+		}
+		opts.rows = numberOfRows
+		images = append(images, fill.tiles(images, missing)...)
+		opts.rotations, err = tileAngles(*rotate, *seed, images)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if *stylePath != "" {
+			styles, err := readTileStyles(*stylePath, args[2:], images)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			opts = opts.withTileStyles(styles)
+		}
+		interactive := *edit || (*outputPath == "" && !*clipboard && PreviewMode(*preview) == PreviewWindow)
+		if *manifestPath != "" && (interactive || isVideoOutput(*outputPath)) {
+			logger.Fatal("Writing a manifest needs a collage saved with -o, not -edit, the viewer or a video")
+		}
+		if interactive {
+			savePath := *outputPath
+			if savePath == "" {
+				savePath = "collage.png"
+			}
+			output, err = viewCollage(opts, images, savePath)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			viewed = true
+		} else if isVideoOutput(*outputPath) {
+			style, err := parseSlideshowStyle(*videoStyle)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			show := Slideshow{style: style, step: *videoStep, seed: *seed}
+			switch {
+			case show.step < 0:
+				logger.Fatal("Video step must not be negative")
+			case show.step == 0 && style == SlideshowShuffle:
+				show.step = 2
+			case show.step == 0:
+				show.step = 0.4
+			}
+			if err := writeSlideshow(*outputPath, opts, images, show); err != nil {
+				logger.Fatal(err)
+			}
+			logger.Debugf("Wrote %s", *outputPath)
+		} else if *manifestPath != "" {
+			if problem := opts.manifestProblem(); problem != "" {
+				logger.Fatalf("Cannot write a manifest with %s", problem)
+			}
+			if *outputPath == "" || *outputPath == "-" || isRemote(*outputPath) {
+				logger.Fatal("Writing a manifest needs a local -o file")
+			}
+			opts.placements = make(map[image.Image]image.Rectangle)
+			if output, err = renderLayout(opts, "grid", images); err != nil {
+				logger.Fatal(err)
+			}
+			manifest = newManifest(opts, *filterEffect, output, images, sources)
+			manifest.Output = *outputPath
+		} else if output, err = renderLayout(opts, "grid", images); err != nil {
+			logger.Fatal(err)
+		}
+	default:
+		if !isRegisteredLayout(*layout) {
+			logger.Fatalf("Unknown layout %q", *layout)
+		}
+		if len(args) == 0 && len(groups) == 0 {
+			logger.Fatal("No images defined")
+		}
+		if *columns < 1 {
+			logger.Fatal("Number of columns must be at least 1")
+		}
+
+		// Layouts choose their own column count unless one was given.
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "columns" {
+				opts.columns = *columns
+			}
+		})
+		var err error
+		if output, err = renderLayout(opts, *layout, append(loadImages(opts, args), groupCollages(opts, groups)...)); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	doneLayout()
+
+	if output != nil {
+		pages = append(pages, output)
+	}
+	doneComposite := stats.enter("composite")
+	for i, page := range pages {
+		pages[i] = opts.printPage(opts.borderPage(page))
+	}
+	doneComposite()
+
+	if *clipboard && len(pages) > 0 {
+		if err := copyToClipboard(pages[0].value); err != nil {
+			logger.Fatal(err)
+		}
+		if len(pages) > 1 {
+			logger.Infof("Copied page 1 of %d to the clipboard", len(pages))
+		}
+	}
+
+	if *outputPath != "" {
+		for i, page := range pages {
+			path := pagePath(*outputPath, i, len(pages))
+			if err := saveImage(path, page.value, opts.encoding); err != nil {
+				logger.Fatal(err)
+			}
+			logger.Debugf("Wrote %s (%dx%d)", path, Width(page.value), Height(page.value))
+		}
+	}
+	if manifest != nil {
+		if err := writeManifest(*manifestPath, manifest); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if PreviewMode(*preview) != PreviewWindow {
+		for _, page := range pages {
+			if err := printPreview(os.Stdout, PreviewMode(*preview), page.value); err != nil {
+				logger.Fatal(err)
+			}
+		}
+	} else if *outputPath == "" && !*clipboard && !viewed {
+		values := make([]image.Image, len(pages))
+		for i, page := range pages {
+			values[i] = page.value
+		}
+		imview.Show(values...)
+	}
+
+	if *showStats {
+		if err := stats.report(os.Stderr, logger.json); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if failed {
+		if opts.inputDir != "" {
+			os.RemoveAll(opts.inputDir)
+		}
+		os.Exit(1)
+	}
+
+	// output := MyImage{image.NewRGBA(image.Rectangle{image.ZP, image.Point{400, 400}})}
+
+	// fimg, _ := os.Open("dog.jpg")
+	// defer fimg.Close()
+	// img, _, _ := image.Decode(fimg)
+	// output.drawRaw(img, image.Point{100, 100}, 180, 150)
+	// // output.drawInCircle(img, image.Point{100, 100}, 180, 180, 150)
+
+	// imview.Show(output.value)
+
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Manifest records where every tile of a grid collage went and the hash of
+// the file it came from, so the rerender subcommand can redraw only the
+// tiles whose sources changed on top of the previous output.
+type Manifest struct {
+	Output string `json:"output"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Deep   bool   `json:"deep,omitempty"`
+
+	// The per-tile effects, as their flags spell them.
+	Filter          string  `json:"filter,omitempty"`
+	Vignette        float64 `json:"vignette,omitempty"`
+	VignetteFalloff float64 `json:"vignetteFalloff,omitempty"`
+	IntegerScale    bool    `json:"integerScale,omitempty"`
+
+	// The encoding of the output, so a rerender writes it the same way.
+	Format      string `json:"format,omitempty"`
+	DPI         int    `json:"dpi,omitempty"`
+	Progressive bool   `json:"progressive,omitempty"`
+	Interlace   bool   `json:"interlace,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	// Provenance is the run the embedded metadata records, unless the
+	// output has none; a rerender hashes its sources again.
+	Provenance *ManifestProvenance `json:"provenance,omitempty"`
+
+	Tiles []ManifestTile `json:"tiles"`
+}
+
+// ManifestProvenance is the layout, flags and arguments of the run that
+// rendered the collage.
+type ManifestProvenance struct {
+	Layout string   `json:"layout"`
+	Params []string `json:"params,omitempty"`
+	Args   []string `json:"args,omitempty"`
+}
+
+// ManifestTile is one tile. Tiles without a source, such as placeholders
+// and groups, are kept as they are.
+type ManifestTile struct {
+	Source      string     `json:"source,omitempty"`
+	SHA256      string     `json:"sha256,omitempty"`
+	Rect        [4]int     `json:"rect"`
+	Shape       ImageShape `json:"shape"`
+	Border      int        `json:"border,omitempty"`
+	BorderColor string     `json:"borderColor,omitempty"`
+}
+
+func (t ManifestTile) rect() image.Rectangle {
+	return image.Rect(t.Rect[0], t.Rect[1], t.Rect[2], t.Rect[3])
+}
+
+// manifestProblem names the first option in use that draws a tile beyond
+// its rectangle or depends on the other tiles, which a rerender could not
+// reproduce, or returns "".
+func (o Options) manifestProblem() string {
+	switch {
+	case o.normalize != "":
+		return "-normalize"
+	case o.blend != BlendNormal || o.opacity < 1:
+		return "-blend and -opacity"
+	case o.captions != nil:
+		return "captions"
+	case o.scaleBars:
+		return "-science"
+	case o.paper != image.Point{}:
+		return "-print-size and -preset"
+	case o.border.width > 0 || o.border.radius > 0:
+		return "-border and -corner-radius"
+	}
+	for _, angle := range o.rotations {
+		if angle != 0 {
+			return "rotated tiles"
+		}
+	}
+	for _, s := range o.styles {
+		if s.Blend != "" && s.Blend != BlendNormal || s.Opacity != nil && *s.Opacity < 1 {
+			return "styles with blend or opacity"
+		}
+	}
+	return ""
+}
+
+// newManifest records the tiles placed in opts.placements. sources names
+// the file of each image that came from one.
+func newManifest(opts Options, filter string, collage *MyImage, images []image.Image, sources map[image.Image]string) *Manifest {
+	m := &Manifest{
+		Width:           Width(collage.value),
+		Height:          Height(collage.value),
+		Deep:            opts.deep,
+		Filter:          filter,
+		Vignette:        opts.vignette,
+		VignetteFalloff: opts.vignetteFalloff,
+		IntegerScale:    opts.integerScale,
+		Format:          opts.encoding.format,
+		DPI:             opts.encoding.dpi,
+		Progressive:     opts.encoding.progressive,
+		Interlace:       opts.encoding.interlace,
+		Compression:     opts.encoding.compression,
+	}
+	if p := opts.encoding.metadata; p != nil {
+		m.Provenance = &ManifestProvenance{p.layout, p.params, p.args}
+	}
+	for _, img := range images {
+		r, ok := opts.placements[img]
+		if !ok {
+			continue
+		}
+		t := ManifestTile{Rect: [4]int{r.Min.X, r.Min.Y, r.Max.X, r.Max.Y}, Shape: opts.tileShape(img)}
+		if s := opts.styles[img]; s.Border > 0 {
+			t.Border, t.BorderColor = s.Border, s.BorderColor
+		}
+		// Stdin is read once, so its images stay as they are like
+		// placeholders do.
+		if src, ok := sources[img]; ok && src != "-" && !strings.HasPrefix(src, "-#entry=") {
+			// A source that failed to load is recorded without a hash, so
+			// the rerender after it is fixed draws it.
+			t.Source = src
+			t.SHA256, _ = hashInput(src)
+		}
+		m.Tiles = append(m.Tiles, t)
+	}
+	return m
+}
+
+func hashInput(path string) (string, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func readManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest: %s: %v", path, err)
+	}
+	return &m, nil
+}
+
+func writeManifest(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// rerender redraws the tiles of m whose source hash changed onto the
+// previous output, records their new hashes and returns how many it
+// redrew. Tiles are redrawn exactly like the grid does when the new image
+// has the old aspect ratio and are cropped to cover their rectangle when
+// it has not.
+func (m *Manifest) rerender(opts Options, previous image.Image) (*MyImage, int, error) {
+	if Width(previous) != m.Width || Height(previous) != m.Height {
+		return nil, 0, fmt.Errorf("manifest: the previous output is %dx%d, not %dx%d", Width(previous), Height(previous), m.Width, m.Height)
+	}
+	output := opts.newCanvas(image.Rect(0, 0, m.Width, m.Height))
+	draw.Draw(output.value, output.Bounds(), previous, previous.Bounds().Min, draw.Src)
+
+	changed := 0
+	for i, t := range m.Tiles {
+		if t.Source == "" {
+			continue
+		}
+		sum, err := hashInput(t.Source)
+		if err != nil && t.SHA256 == "" {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if sum == t.SHA256 {
+			continue
+		}
+		logger.Debugf("Redrawing %s", t.Source)
+		img, err := opts.loadImage(t.Source)
+		if err != nil {
+			return nil, 0, err
+		}
+		img = opts.filterTile(img)
+
+		r := t.rect()
+		w, h := uint(r.Dx()), uint(r.Dy())
+		var tile image.Image
+		if opts.tileSize(img, float64(w)) == (Size{w, h}) || t.Shape == CircleShape {
+			tile = opts.vignetteTile(opts.scaleTile(img, float64(w), w, h), t.Shape)
+		} else {
+			tile = opts.vignetteTile(coverTile(img, r.Dx(), r.Dy()), t.Shape)
+		}
+
+		// Clear only what the old tile covered, keeping the corners around
+		// a circle as they were.
+		if t.Shape == CircleShape {
+			mask := &Circle{image.Pt(r.Dx()/2, r.Dy()/2), r.Dx() / 2}
+			draw.DrawMask(output.value, r, image.Transparent, image.ZP, mask, image.ZP, draw.Src)
+			output.drawInCircle(tile, r.Min, r.Dx(), BlendNormal, 1)
+		} else {
+			draw.Draw(output.value, r, image.Transparent, image.ZP, draw.Src)
+			output.drawRaw(tile, r.Min)
+		}
+		if t.Border > 0 {
+			style := TileStyle{Border: t.Border, borderColor: color.White}
+			if t.BorderColor != "" {
+				if style.borderColor, err = parseHexColor(t.BorderColor); err != nil {
+					return nil, 0, err
+				}
+			}
+			output.drawTileBorder(style, t.Shape, r)
+		}
+		m.Tiles[i].SHA256 = sum
+		changed++
+	}
+	return &output, changed, nil
+}
+
+func rerenderMain(args []string) {
+	fs := flag.NewFlagSet("rerender", flag.ExitOnError)
+	configureLogging := addLogFlags(fs)
+	outputPath := fs.String("o", "", "write the collage to this file instead of over the output the manifest names")
+	raw := fs.String("raw", "auto", "camera raw handling: decode (dcraw/libraw), preview (embedded JPEG) or auto")
+	fs.Parse(args)
+	configureLogging()
+
+	if fs.NArg() != 1 {
+		logger.Fatal("Usage: imagecollager rerender [flags] <manifest.json>")
+	}
+	path := fs.Arg(0)
+	m, err := readManifest(path)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	opts := Options{
+		raw:             RawMode(*raw),
+		deep:            m.Deep,
+		vignette:        m.Vignette,
+		vignetteFalloff: m.VignetteFalloff,
+		integerScale:    m.IntegerScale,
+	}
+	if opts.filter, err = parseFilter(m.Filter); err != nil {
+		logger.Fatal(err)
+	}
+
+	// Archive members are read back into memory as the collage did.
+	dir, err := ioutil.TempDir("", "imagecollager-")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archives := make(map[string]bool)
+	for _, t := range m.Tiles {
+		if i := strings.LastIndex(t.Source, "#entry="); i >= 0 && !archives[t.Source[:i]] {
+			archives[t.Source[:i]] = true
+			if _, err := expandArchives([]string{t.Source[:i]}, dir); err != nil {
+				logger.Fatal(err)
+			}
+		}
+	}
+
+	// The output and sources are named as they were given, so rerender
+	// from the directory the collage was rendered in.
+	previous, err := opts.loadImage(m.Output)
+	if err != nil {
+		logger.Fatalf("Cannot read the previous output: %v", err)
+	}
+	output, changed, err := m.rerender(opts, previous)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Infof("Redrew %d of %d tiles", changed, len(m.Tiles))
+	// Writing an unchanged collage over itself would only lose quality
+	// to another round of lossy encoding.
+	if changed == 0 && (*outputPath == "" || *outputPath == m.Output) {
+		return
+	}
+
+	if *outputPath != "" {
+		m.Output = *outputPath
+	}
+	encoding := Encoding{
+		format:      m.Format,
+		dpi:         m.DPI,
+		progressive: m.Progressive,
+		interlace:   m.Interlace,
+		compression: m.Compression,
+	}
+	if p := m.Provenance; p != nil {
+		if encoding.metadata, err = hashProvenance(p.Layout, p.Params, p.Args); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	if err := saveImage(m.Output, output.value, encoding); err != nil {
+		logger.Fatal(err)
+	}
+	if err := writeManifest(path, m); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// MosaicCells is a partition of the canvas: for each pixel the cell it
+// belongs to and its distance to the nearest cell edge.
+type MosaicCells struct {
+	rect   image.Rectangle
+	count  int
+	labels []int32
+	edge   []float32
+}
+
+// voronoiCells spreads one seed per cell over a jittered grid, relaxes the
+// seeds toward their cell centroids so cells even out, and assigns every
+// pixel to its nearest seed.
+func voronoiCells(width int, height int, n int, rng *rand.Rand) *MosaicCells {
+	cols := int(math.Max(1, math.Round(math.Sqrt(float64(n)*float64(width)/float64(height)))))
+	rows := (n + cols - 1) / cols
+	seeds := make([][2]float64, n)
+	for i := range seeds {
+		col, row := i%cols, i/cols
+		seeds[i] = [2]float64{
+			(float64(col) + 0.15 + 0.7*rng.Float64()) * float64(width) / float64(cols),
+			(float64(row) + 0.15 + 0.7*rng.Float64()) * float64(height) / float64(rows),
+		}
+	}
+
+	// Lloyd relaxation on a coarse grid.
+	const step, iterations = 4, 3
+	for it := 0; it < iterations; it++ {
+		sums := make([][3]float64, n)
+		for y := step / 2; y < height; y += step {
+			for x := step / 2; x < width; x += step {
+				i, _, _ := nearestSeeds(seeds, float64(x), float64(y))
+				sums[i][0] += float64(x)
+				sums[i][1] += float64(y)
+				sums[i][2]++
+			}
+		}
+		for i, s := range sums {
+			if s[2] > 0 {
+				seeds[i] = [2]float64{s[0] / s[2], s[1] / s[2]}
+			}
+		}
+	}
+
+	cells := newMosaicCells(width, height, n)
+	parallel(height, 0, func(y int) {
+		for x := 0; x < width; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			a, b, ok := nearestSeeds(seeds, px, py)
+			cells.labels[y*width+x] = int32(a)
+			if !ok {
+				cells.edge[y*width+x] = math.MaxFloat32
+				continue
+			}
+			// Distance to the bisector between the nearest two seeds.
+			sa, sb := seeds[a], seeds[b]
+			da := (px-sa[0])*(px-sa[0]) + (py-sa[1])*(py-sa[1])
+			db := (px-sb[0])*(px-sb[0]) + (py-sb[1])*(py-sb[1])
+			cells.edge[y*width+x] = float32((db - da) / (2 * math.Hypot(sa[0]-sb[0], sa[1]-sb[1])))
+		}
+	})
+	return cells
+}
+
+// nearestSeeds returns the nearest and second nearest seed to (x, y), and
+// false when there is only one.
+func nearestSeeds(seeds [][2]float64, x float64, y float64) (int, int, bool) {
+	a, b := 0, -1
+	da, db := math.Inf(1), math.Inf(1)
+	for i, s := range seeds {
+		d := (x-s[0])*(x-s[0]) + (y-s[1])*(y-s[1])
+		if d < da {
+			b, db = a, da
+			a, da = i, d
+		} else if d < db {
+			b, db = i, d
+		}
+	}
+	if len(seeds) < 2 {
+		return a, 0, false
+	}
+	return a, b, true
+}
+
+// triangleCells cuts the canvas into rows and each row into triangles by
+// a zigzag of lines between its top and bottom edges: a row of m cells
+// has m-1 lines, a right triangle at each end and isosceles ones between.
+// The row count is chosen so the triangles are about as wide as high.
+func triangleCells(width int, height int, n int) *MosaicCells {
+	rows, best := 1, math.Inf(1)
+	for r := 1; r <= n; r++ {
+		h := float64(height) / float64(r)
+		m := rowLengths(n, r)[0]
+		base := float64(width)
+		if m > 1 {
+			base = 2 * float64(width) / float64(m-1)
+		}
+		if d := math.Abs(math.Log(base / h)); d < best {
+			rows, best = r, d
+		}
+	}
+
+	cells := newMosaicCells(width, height, n)
+	lengths := rowLengths(n, rows)
+	first := 0
+	for r, m := range lengths {
+		top, bottom := r*height/rows, (r+1)*height/rows
+		h := float64(bottom - top)
+		spacing := float64(width) / math.Max(1, float64(m-1))
+		label := first
+		parallel(bottom-top, 0, func(dy int) {
+			y := top + dy
+			fy := (float64(dy) + 0.5) / h
+			for x := 0; x < width; x++ {
+				px := float64(x) + 0.5
+				cell, edge := 0, math.MaxFloat32
+				if r > 0 {
+					edge = float64(dy) + 0.5
+				}
+				if r < rows-1 {
+					edge = math.Min(edge, h-float64(dy)-0.5)
+				}
+				for k := 0; k < m-1; k++ {
+					// Line k runs from vertex k to vertex k+1, alternating
+					// between the edges, flipped on odd rows.
+					x0, x1 := float64(k)*spacing, float64(k+1)*spacing
+					if (k+r)%2 == 1 {
+						x0, x1 = x1, x0
+					}
+					lx := x0 + (x1-x0)*fy
+					if px > lx {
+						cell++
+					}
+					d := math.Abs(px-lx) * h / math.Hypot(h, x1-x0)
+					edge = math.Min(edge, d)
+				}
+				cells.labels[y*width+x] = int32(label + cell)
+				cells.edge[y*width+x] = float32(edge)
+			}
+		})
+		first += m
+	}
+	return cells
+}
+
+func newMosaicCells(width int, height int, n int) *MosaicCells {
+	return &MosaicCells{
+		rect:   image.Rect(0, 0, width, height),
+		count:  n,
+		labels: make([]int32, width*height),
+		edge:   make([]float32, width*height),
+	}
+}
+
+// bounds is the bounding box of each cell.
+func (c *MosaicCells) bounds() []image.Rectangle {
+	boxes := make([]image.Rectangle, c.count)
+	w := c.rect.Dx()
+	for i, l := range c.labels {
+		p := image.Pt(i%w, i/w)
+		cell := image.Rectangle{p, p.Add(image.Pt(1, 1))}
+		if boxes[l].Empty() {
+			boxes[l] = cell
+		} else {
+			boxes[l] = boxes[l].Union(cell)
+		}
+	}
+	return boxes
+}
+
+// CellMask is opaque inside one mosaic cell, fading out over the pixel at
+// half the gap from its edges.
+type CellMask struct {
+	cells *MosaicCells
+	cell  int32
+	gap   float64
+}
+
+func (m *CellMask) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (m *CellMask) Bounds() image.Rectangle {
+	return m.cells.rect
+}
+
+func (m *CellMask) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(m.cells.rect) {
+		return color.Alpha{0}
+	}
+	i := y*m.cells.rect.Dx() + x
+	if m.cells.labels[i] != m.cell {
+		return color.Alpha{0}
+	}
+	if m.gap <= 0 {
+		return color.Alpha{0xff}
+	}
+	return color.Alpha{uint8(255 * clamp01(float64(m.cells.edge[i])-m.gap/2+0.5))}
+}
+
+// makeMosaic clips each image to a cell of the partition, cropped to cover
+// the cell's bounding box. Images are matched to cells by aspect ratio, so
+// wide photos land in wide cells, unless keepOrder puts them in cell
+// order, and gap pixels separate the cells.
+func makeMosaic(opts Options, cells *MosaicCells, images []image.Image, gap float64) *MyImage {
+	boxes := cells.bounds()
+	byCell := make([]int, cells.count)
+	byImage := make([]int, len(images))
+	for i := range byCell {
+		byCell[i] = i
+	}
+	for i := range byImage {
+		byImage[i] = i
+	}
+	if !opts.keepOrder {
+		sort.SliceStable(byCell, func(i, j int) bool {
+			a, b := boxes[byCell[i]], boxes[byCell[j]]
+			return float64(a.Dx())/float64(a.Dy()) > float64(b.Dx())/float64(b.Dy())
+		})
+		sort.SliceStable(byImage, func(i, j int) bool {
+			return aspect(images[byImage[i]]) > aspect(images[byImage[j]])
+		})
+	}
+
+	output := opts.newCanvas(cells.rect)
+	tiles := make([]image.Image, len(images))
+	parallel(len(images), largestDecoded(images), func(k int) {
+		if box := boxes[byCell[k]]; !box.Empty() {
+			tiles[k] = coverTile(images[byImage[k]], box.Dx(), box.Dy())
+		}
+	})
+	for k, tile := range tiles {
+		box := boxes[byCell[k]]
+		if tile == nil {
+			continue
+		}
+		draw.DrawMask(output.value, box, tile, tile.Bounds().Min, &CellMask{cells, int32(byCell[k]), gap}, box.Min, draw.Over)
+	}
+	return &output
+}
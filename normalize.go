@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+type Normalization string
+
+const (
+	NormalizeNone   Normalization = ""
+	NormalizeMean   Normalization = "mean"
+	NormalizeLevels Normalization = "levels"
+)
+
+// levelsClip is the fraction of pixels auto-levels lets clip at each end.
+const levelsClip = 0.005
+
+func parseNormalization(s string) (Normalization, error) {
+	switch n := Normalization(s); n {
+	case NormalizeNone, NormalizeMean, NormalizeLevels:
+		return n, nil
+	}
+	return NormalizeNone, fmt.Errorf("unknown normalization %q, expected mean or levels", s)
+}
+
+// lumaHistogram samples about 64k pixels, which is plenty for the mean and
+// percentiles without walking every pixel of a large photo. The bins are
+// 8-bit luminance levels, also for deep images.
+func lumaHistogram(img image.Image) ([256]int, int) {
+	var hist [256]int
+	b := img.Bounds()
+	step := int(math.Max(1, math.Sqrt(float64(b.Dx()*b.Dy())/65536)))
+	n := 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			var c color.NRGBA64
+			if isDeep(img) {
+				c = color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			} else {
+				c = widen(color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+			}
+			hist[clampByte(luma(c)/257)]++
+			n++
+		}
+	}
+	return hist, n
+}
+
+func meanLuma(img image.Image) float64 {
+	hist, n := lumaHistogram(img)
+	sum := 0
+	for v, count := range hist {
+		sum += v * count
+	}
+	return float64(sum) / float64(n) / 255
+}
+
+// gammaFilter brings an image with mean luminance mean to target. A gamma
+// curve is used rather than a gain so highlights are not blown out.
+func gammaFilter(mean float64, target float64) ColorFilter {
+	mean = math.Min(math.Max(mean, 0.01), 0.99)
+	gamma := math.Log(target) / math.Log(mean)
+	return curveFilter(func(v float64) float64 {
+		return 0xffff * math.Pow(v/0xffff, gamma)
+	})
+}
+
+// levelsFilter stretches the luminance range between the clip percentiles
+// of img to the full range.
+func levelsFilter(img image.Image) ColorFilter {
+	hist, n := lumaHistogram(img)
+	lo, hi := 0, 255
+	for count := 0; lo < 255; lo++ {
+		if count += hist[lo]; float64(count) > levelsClip*float64(n) {
+			break
+		}
+	}
+	for count := 0; hi > 0; hi-- {
+		if count += hist[hi]; float64(count) > levelsClip*float64(n) {
+			break
+		}
+	}
+	if hi <= lo {
+		return func(c color.NRGBA64) color.NRGBA64 { return c }
+	}
+	return curveFilter(func(v float64) float64 {
+		return (v - 257*float64(lo)) * 255 / float64(hi-lo)
+	})
+}
+
+// normalizeTiles evens out exposure across a set of tiles: "mean" moves every
+// tile to the average mean luminance of the set, "levels" auto-levels each
+// tile on its own. Tiles come out at 16 bits a channel when deep or when
+// they are deep already.
+func normalizeTiles(images []image.Image, mode Normalization, deep bool) []image.Image {
+	out := make([]image.Image, len(images))
+	switch mode {
+	case NormalizeMean:
+		means := make([]float64, len(images))
+		target := 0.0
+		for i, img := range images {
+			means[i] = meanLuma(img)
+			target += means[i] / float64(len(images))
+		}
+		target = math.Min(math.Max(target, 0.01), 0.99)
+		for i, img := range images {
+			out[i] = gammaFilter(means[i], target).apply(img, deep)
+		}
+	case NormalizeLevels:
+		for i, img := range images {
+			out[i] = levelsFilter(img).apply(img, deep)
+		}
+	default:
+		copy(out, images)
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// Encoding holds output settings that change the file but not the pixels.
+type Encoding struct {
+	// dpi, when positive, is written into the file as its print resolution.
+	dpi int
+	// format, as an extension such as ".jpg", overrides the one of the
+	// output path. Stdout has none and defaults to PNG.
+	format string
+	// progressive JPEG and interlaced PNG output draw a coarse preview
+	// first when loaded over a slow connection.
+	progressive bool
+	interlace   bool
+	// compression of TIFF output: "" or "none", "lzw" or "deflate".
+	compression string
+	// metadata, unless nil, is embedded to record how the image was made.
+	metadata *Provenance
+}
+
+// outputFormat is the format path is written in.
+func (e Encoding) outputFormat(path string) string {
+	if e.format != "" {
+		return e.format
+	}
+	if path == "-" {
+		return ".png"
+	}
+	return strings.ToLower(filepath.Ext(path))
+}
+
+func encodeImage(w io.Writer, format string, img image.Image, enc Encoding) error {
+	defer stats.enter("encode")()
+	var buf bytes.Buffer
+	if err := encodePixels(&buf, format, img, enc); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	var err error
+	if enc.progressive && (format == ".jpg" || format == ".jpeg") {
+		data, err = progressiveJPEG(data)
+	}
+	if err == nil && enc.dpi > 0 {
+		data, err = setDPI(format, data, enc.dpi)
+	}
+	if err == nil && enc.metadata != nil {
+		data, err = setMetadata(format, data, enc.metadata)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func encodePixels(f io.Writer, format string, img image.Image, enc Encoding) error {
+	switch format {
+	case ".png":
+		if enc.interlace {
+			return encodeInterlacedPNG(f, img)
+		}
+		return png.Encode(f, img)
+	case ".jpg", ".jpeg":
+		return jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+	case ".tif", ".tiff":
+		switch enc.compression {
+		case "deflate":
+			return tiff.Encode(f, img, &tiff.Options{Compression: tiff.Deflate})
+		case "lzw":
+			var buf bytes.Buffer
+			if err := tiff.Encode(&buf, img, nil); err != nil {
+				return err
+			}
+			data, err := compressTIFF(buf.Bytes())
+			if err != nil {
+				return err
+			}
+			_, err = f.Write(data)
+			return err
+		}
+		return tiff.Encode(f, img, nil)
+	case ".bmp":
+		return bmp.Encode(f, img)
+	}
+	return fmt.Errorf("unsupported output format %q", format)
+}
+
+// saveImage writes img to path, to an s3://, gs:// or az:// object, or with
+// a path of "-" to stdout.
+func saveImage(path string, img image.Image, enc Encoding) error {
+	if isRemote(path) {
+		return uploadImage(path, img, enc)
+	}
+	if path == "-" {
+		w := bufio.NewWriter(os.Stdout)
+		if err := encodeImage(w, enc.outputFormat(path), img, enc); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := encodeImage(f, enc.outputFormat(path), img, enc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pagePath numbers the output file when a layout produces several pages:
+// sheet.png becomes sheet-001.png, sheet-002.png, ... Pages written to
+// stdout follow each other in the one stream.
+func pagePath(path string, page int, pages int) string {
+	if pages <= 1 || path == "-" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(path, ext), page+1, ext)
+}
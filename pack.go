@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PackStrategy decides which images share a grid row.
+type PackStrategy string
+
+const (
+	// PackIndex sorts images tallest first and slices the list into rows
+	// of nearly equal length.
+	PackIndex PackStrategy = "index"
+	// PackGreedy fills rows first-fit-decreasing: widest image first, each
+	// goes to the first row it fits in without passing the target width,
+	// an even share of the images' total width at a common height.
+	PackGreedy PackStrategy = "greedy"
+	// PackBalanced lets row lengths vary and picks the split with the
+	// least whitespace under the shorter tiles of each row.
+	PackBalanced PackStrategy = "balanced"
+)
+
+func parsePackStrategy(s string) (PackStrategy, error) {
+	switch p := PackStrategy(s); p {
+	case "":
+		return PackIndex, nil
+	case PackIndex, PackGreedy, PackBalanced:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown packing %q; use index, greedy or balanced", s)
+}
+
+// parseRowSpec reads the tiles per grid row, top to bottom, from a list
+// such as "1,3,3,2".
+func parseRowSpec(s string) ([]int, error) {
+	var lengths []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("row spec %q: every row needs a count of at least 1", s)
+		}
+		lengths = append(lengths, n)
+	}
+	return lengths, nil
+}
+
+func aspect(img image.Image) float64 {
+	return float64(Width(img)) / float64(Height(img))
+}
+
+// rowLengths is the index split: rows of len(images)/rows tiles, the first
+// ones one longer when the division leaves a remainder.
+func rowLengths(images int, rows int) []int {
+	lengths := make([]int, rows)
+	for i := range lengths {
+		lengths[i] = images / rows
+		if i < images%rows {
+			lengths[i]++
+		}
+	}
+	return lengths
+}
+
+// packRows sorts images in place, unless keepOrder, and cuts them into
+// rows according to the packing strategy. The sorts are stable so images
+// that tie keep their argument order and the same inputs always give the
+// same bytes. An explicit rowSpec cuts the images in their order instead.
+func (o Options) packRows(images []image.Image) [][]image.Image {
+	if !o.keepOrder && o.rowSpec == nil {
+		switch o.pack {
+		case PackGreedy, PackBalanced:
+			sort.SliceStable(images, func(i, j int) bool {
+				return aspect(images[i]) > aspect(images[j])
+			})
+		default:
+			sort.SliceStable(images, func(i, j int) bool {
+				return Height(images[i]) > Height(images[j])
+			})
+		}
+	}
+
+	if o.pack == PackGreedy && o.rowSpec == nil {
+		return greedyRows(images, o.rows)
+	}
+	lengths := rowLengths(len(images), o.rows)
+	switch {
+	case o.rowSpec != nil:
+		lengths = o.rowSpec
+	case o.pack == PackBalanced:
+		lengths = balancedRowLengths(images, o.rows)
+	}
+	rows := make([][]image.Image, len(lengths))
+	start := 0
+	for i, n := range lengths {
+		rows[i] = images[start : start+n]
+		start += n
+	}
+	return rows
+}
+
+// balancedRowLengths splits images, in their current order, into rows
+// non-empty runs with the least total whitespace, by dynamic programming
+// over where each row starts. At unit row width each of the k tiles of a
+// row is 1/k wide and 1/(k*a) high for aspect ratio a, and the row is as
+// high as its tallest tile; the whitespace is the area under the others.
+func balancedRowLengths(images []image.Image, rows int) []int {
+	n := len(images)
+	inf := math.Inf(1)
+	// cost[r][j] is the least whitespace of the first j images in r rows,
+	// and cut[r][j] where the last of those rows starts.
+	cost := make([][]float64, rows+1)
+	cut := make([][]int, rows+1)
+	for r := range cost {
+		cost[r] = make([]float64, n+1)
+		cut[r] = make([]int, n+1)
+		for j := range cost[r] {
+			cost[r][j] = inf
+		}
+	}
+	cost[0][0] = 0
+	for r := 1; r <= rows; r++ {
+		for j := r; j <= n-(rows-r); j++ {
+			tallest, sum := 0.0, 0.0
+			for i := j - 1; i >= r-1; i-- {
+				h := 1 / aspect(images[i])
+				tallest, sum = math.Max(tallest, h), sum+h
+				if cost[r-1][i] == inf {
+					continue
+				}
+				k := float64(j - i)
+				if c := cost[r-1][i] + (k*tallest-sum)/(k*k); c < cost[r][j] {
+					cost[r][j], cut[r][j] = c, i
+				}
+			}
+		}
+	}
+
+	lengths := make([]int, rows)
+	for r, j := rows, n; r > 0; r-- {
+		lengths[r-1] = j - cut[r][j]
+		j = cut[r][j]
+	}
+	return lengths
+}
+
+// greedyRows bins images, in their current order, into rows by first fit.
+// At a common row height an image is as wide as its aspect ratio, so each
+// row aims for the sum of the ratios over rows. An image too wide for the
+// room left in every row goes to the narrowest one.
+func greedyRows(images []image.Image, rows int) [][]image.Image {
+	total := 0.0
+	for _, img := range images {
+		total += aspect(img)
+	}
+	target := total / float64(rows)
+
+	bins := make([][]image.Image, rows)
+	widths := make([]float64, rows)
+	for _, img := range images {
+		a := aspect(img)
+		row := -1
+		for r := range bins {
+			if widths[r]+a <= target*(1+1e-9) {
+				row = r
+				break
+			}
+		}
+		if row < 0 {
+			row = 0
+			for r := range widths {
+				if widths[r] < widths[row] {
+					row = r
+				}
+			}
+		}
+		bins[row] = append(bins[row], img)
+		widths[row] += a
+	}
+
+	// Rounding can leave a row empty when the others fill up to the
+	// target exactly; give it the last image of the longest row.
+	for r := range bins {
+		if len(bins[r]) > 0 {
+			continue
+		}
+		longest := 0
+		for k := range bins {
+			if len(bins[k]) > len(bins[longest]) {
+				longest = k
+			}
+		}
+		if len(bins[longest]) < 2 {
+			break
+		}
+		last := len(bins[longest]) - 1
+		bins[r] = []image.Image{bins[longest][last]}
+		bins[longest] = bins[longest][:last]
+	}
+	return bins
+}
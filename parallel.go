@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+)
+
+// minBandHeight keeps composite bands tall enough that splitting a small
+// canvas costs less than it saves.
+const minBandHeight = 64
+
+// parallel calls f(0) to f(n-1), each needing about scratch bytes, from
+// as many goroutines as the limits allow and returns once all calls have.
+func parallel(n int, scratch int64, f func(i int)) {
+	workers := limits.workerCount(n, scratch)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// compositeBands runs draws, in order, on horizontal bands of canvas in
+// parallel. Each band is a sub-image sharing the canvas pixels, so draws
+// clip to it and every pixel sees the same sequence of draws as when
+// compositing serially.
+func compositeBands(canvas *MyImage, draws []func(dst *MyImage)) {
+	b := canvas.value.Bounds()
+	bands := limits.workerCount(b.Dy()/minBandHeight, 0)
+	if bands < 2 {
+		for _, d := range draws {
+			d(canvas)
+		}
+		return
+	}
+
+	sub := canvas.value.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	parallel(bands, 0, func(i int) {
+		r := image.Rect(b.Min.X, b.Min.Y+i*b.Dy()/bands, b.Max.X, b.Min.Y+(i+1)*b.Dy()/bands)
+		band := MyImage{sub.SubImage(r).(draw.Image)}
+		for _, d := range draws {
+			d(&band)
+		}
+	})
+}
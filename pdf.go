@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pdfResolution is the DPI pages are rendered at, enough for an A4 page to
+// fill a tile on the default 800 pixel canvas several times over.
+const pdfResolution = 150
+
+// splitPDFPath separates "deck.pdf#3" or "deck.pdf#2-5" into the file and
+// its page spec. ok is false for anything that is not a PDF.
+func splitPDFPath(path string) (file string, pages string, ok bool) {
+	file = path
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		file, pages = path[:i], path[i+1:]
+	}
+	return file, pages, strings.ToLower(filepath.Ext(file)) == ".pdf"
+}
+
+func runPDFTool(tools [][]string) ([]byte, error) {
+	for _, args := range tools {
+		p, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command(p, args[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, errors.New("pdf: " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+		}
+		return out, nil
+	}
+	return nil, errors.New("pdf: neither poppler (pdfinfo, pdftoppm) nor mutool found in PATH")
+}
+
+func pdfPageCount(file string) (int, error) {
+	out, err := runPDFTool([][]string{{"pdfinfo", file}, {"mutool", "info", file}})
+	if err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "Pages:" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+	return 0, errors.New("pdf: page count not reported for " + file)
+}
+
+// parsePageRange accepts "", "N", "N-M" and "N-"; the empty spec selects
+// every page.
+func parsePageRange(spec string, count int) (first int, last int, err error) {
+	first, last = 1, count
+	if spec != "" {
+		parts := strings.SplitN(spec, "-", 2)
+		if first, err = strconv.Atoi(parts[0]); err != nil {
+			return 0, 0, fmt.Errorf("pdf: invalid page range %q", spec)
+		}
+		last = first
+		if len(parts) == 2 && parts[1] != "" {
+			if last, err = strconv.Atoi(parts[1]); err != nil {
+				return 0, 0, fmt.Errorf("pdf: invalid page range %q", spec)
+			}
+		} else if len(parts) == 2 {
+			last = count
+		}
+	}
+	if first < 1 || last > count || first > last {
+		return 0, 0, fmt.Errorf("pdf: page range %q outside 1-%d", spec, count)
+	}
+	return first, last, nil
+}
+
+// expandPDFs replaces every PDF argument with one "file.pdf#N" path per
+// selected page, so each page becomes its own tile and keeps a name for
+// captions. Other paths pass through unchanged.
+func expandPDFs(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		file, spec, ok := splitPDFPath(path)
+		if !ok {
+			expanded = append(expanded, path)
+			continue
+		}
+		count, err := pdfPageCount(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		first, last, err := parsePageRange(spec, count)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for page := first; page <= last; page++ {
+			expanded = append(expanded, fmt.Sprintf("%s#%d", file, page))
+		}
+	}
+	return expanded, nil
+}
+
+// renderPDFPage renders one page to PNG on stdout and decodes it. A path
+// without a page spec renders the first page.
+func renderPDFPage(path string) (image.Image, error) {
+	file, spec, _ := splitPDFPath(path)
+	page := 1
+	if spec != "" {
+		n, err := strconv.Atoi(spec)
+		if err != nil {
+			return nil, fmt.Errorf("pdf: expected a single page, got %q", spec)
+		}
+		page = n
+	}
+
+	p, r := strconv.Itoa(page), strconv.Itoa(pdfResolution)
+	out, err := runPDFTool([][]string{
+		{"pdftoppm", "-png", "-r", r, "-f", p, "-l", p, "-singlefile", file, "-"},
+		{"mutool", "draw", "-q", "-r", r, "-F", "png", "-o", "-", file, p},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(out)
+}
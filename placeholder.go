@@ -0,0 +1,115 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"path/filepath"
+
+	"github.com/nfnt/resize"
+)
+
+// placeholderWidth is the width placeholder tiles are made at; the layout
+// scales them to their cells like any other tile.
+const placeholderWidth = 256
+
+// Placeholder fills the empty slots of the last grid row: with a solid
+// color, a blurred copy of an image, or a logo centered on a transparent
+// tile.
+type Placeholder struct {
+	color color.Color
+	blur  bool
+	logo  image.Image
+}
+
+// parsePlaceholder accepts "blur", a #rrggbb color or the path of an image
+// to use as the logo.
+func (o Options) parsePlaceholder(spec string) (*Placeholder, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "blur":
+		return &Placeholder{blur: true}, nil
+	case len(spec) > 0 && spec[0] == '#':
+		c, err := parseHexColor(spec)
+		if err != nil {
+			return nil, err
+		}
+		return &Placeholder{color: c}, nil
+	}
+	logo, err := o.loadImage(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &Placeholder{logo: logo}, nil
+}
+
+// tiles returns n placeholders, as many as the last row is short. Each
+// takes the aspect ratio of an image, in turn from the first, so the rows
+// keep their heights.
+func (p *Placeholder) tiles(images []image.Image, n int) []image.Image {
+	if p == nil || len(images) == 0 {
+		return nil
+	}
+	tiles := make([]image.Image, n)
+	for i := range tiles {
+		like := images[i%len(images)]
+		h := int(math.Max(1, math.Round(placeholderWidth/aspect(like))))
+		switch {
+		case p.blur:
+			tiles[i] = blurTile(like, placeholderWidth, h)
+		case p.logo != nil:
+			tiles[i] = logoTile(p.logo, placeholderWidth, h)
+		default:
+			tile := image.NewRGBA(image.Rect(0, 0, placeholderWidth, h))
+			draw.Draw(tile, tile.Rect, image.NewUniform(p.color), image.ZP, draw.Src)
+			tiles[i] = tile
+		}
+	}
+	return tiles
+}
+
+// blurTile shrinks img to a few pixels across and scales it back up
+// bilinearly, which smears out all detail.
+func blurTile(img image.Image, width int, height int) image.Image {
+	small := resample(img, 12, 0)
+	return resize.Resize(uint(width), uint(height), small, resize.Bilinear)
+}
+
+// logoTile centers logo, fitted to two thirds of the tile, on a
+// transparent width x height tile.
+func logoTile(logo image.Image, width int, height int) image.Image {
+	scale := math.Min(float64(width)/float64(Width(logo)), float64(height)/float64(Height(logo))) * 2 / 3
+	fitted := resample(logo, uint(math.Max(1, math.Round(float64(Width(logo))*scale))), uint(math.Max(1, math.Round(float64(Height(logo))*scale))))
+	tile := image.NewRGBA(image.Rect(0, 0, width, height))
+	at := image.Pt((width-Width(fitted))/2, (height-Height(fitted))/2)
+	draw.Draw(tile, fitted.Bounds().Sub(fitted.Bounds().Min).Add(at), fitted, fitted.Bounds().Min, draw.Over)
+	return tile
+}
+
+var brokenColor = color.RGBA{0x9e, 0x1b, 0x32, 0xff}
+
+// brokenTile is the placeholder for an input that failed to load: a red
+// box with a cross and the file name, shortened to fit.
+func brokenTile(path string) image.Image {
+	const width, height = placeholderWidth, placeholderWidth * 3 / 4
+	tile := MyImage{image.NewRGBA(image.Rect(0, 0, width, height))}
+	draw.Draw(&tile, tile.Bounds(), image.NewUniform(brokenColor), image.ZP, draw.Src)
+	for i := 0; i < height; i++ {
+		x := i * width / height
+		tile.Set(x, i, color.White)
+		tile.Set(width-1-x, i, color.White)
+	}
+
+	runes := []rune(filepath.Base(path))
+	name := string(runes)
+	for textWidth(name) > width-16 && len(runes) > 1 {
+		runes = runes[:len(runes)-1]
+		name = string(runes) + "..."
+	}
+	box := image.Rect((width-textWidth(name))/2-4, (height-labelHeight)/2-3, (width+textWidth(name))/2+4, (height+labelHeight)/2+3)
+	draw.Draw(&tile, box, image.NewUniform(brokenColor), image.ZP, draw.Src)
+	tile.drawString(name, box.Min.X+4, box.Min.Y+3+labelFace.Ascent, color.White)
+	return tile.value
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Preset is a canvas for posting to a social platform: its size in pixels,
+// a margin kept clear of tiles on every side, and the format it is
+// uploaded in. Vertical formats also keep safe zones clear at the top and
+// bottom, where the platform overlays its own controls.
+type Preset struct {
+	width      int
+	height     int
+	margin     int
+	format     string
+	safeTop    int
+	safeBottom int
+}
+
+var presets = map[string]Preset{
+	"instagram-square": {1080, 1080, 40, ".jpg", 0, 0},
+	// The profile bar covers the top of a story and the reply field its
+	// bottom.
+	"instagram-story": {1080, 1920, 64, ".jpg", 250, 250},
+	"twitter-header":  {1500, 500, 40, ".jpg", 0, 0},
+	// Link previews are recompressed by every site that shows them, so
+	// they start lossless.
+	"og-image": {1200, 630, 40, ".png", 0, 0},
+}
+
+func parsePreset(s string) (Preset, error) {
+	if p, ok := presets[strings.ToLower(s)]; ok {
+		return p, nil
+	}
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return Preset{}, fmt.Errorf("unknown preset %q; use %s", s, strings.Join(names, ", "))
+}
+
+// apply lays out within the preset's margins and safe zones and fits the
+// result onto a canvas of exactly its size, as for a print size. Safe
+// zones already in o are kept where wider than the preset's. The preset's
+// format is used unless the output path or -format names one.
+func (p Preset) apply(o Options, outputPath string) Options {
+	o.paper.X, o.paper.Y = p.width, p.height
+	o.margin = p.margin
+	if p.safeTop > o.safeTop {
+		o.safeTop = p.safeTop
+	}
+	if p.safeBottom > o.safeBottom {
+		o.safeBottom = p.safeBottom
+	}
+	top, bottom := o.pageInsets()
+	o.width, o.height = p.width-2*p.margin, p.height-top-bottom
+	if o.encoding.format == "" && (outputPath == "-" || Encoding{}.outputFormat(outputPath) == "") {
+		o.encoding.format = p.format
+	}
+	return o
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// PreviewMode selects how the collage is shown when it is not only saved:
+// in an imview window, or inline in the terminal. Term picks the best
+// protocol the terminal advertises; the others force one.
+type PreviewMode string
+
+const (
+	PreviewWindow PreviewMode = "window"
+	PreviewTerm   PreviewMode = "term"
+	PreviewSixel  PreviewMode = "sixel"
+	PreviewITerm  PreviewMode = "iterm"
+	PreviewKitty  PreviewMode = "kitty"
+	PreviewANSI   PreviewMode = "ansi"
+)
+
+// maxSixelWidth keeps sixel output, which terminals never rescale, within
+// a typical terminal window.
+const maxSixelWidth = 1000
+
+// detectTerminal reads the environment variables terminals set about
+// themselves. Sixel support cannot be queried without a round trip to the
+// terminal, so it is only assumed for terminals known to have it.
+func detectTerminal() PreviewMode {
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || program == "ghostty":
+		return PreviewKitty
+	case program == "iTerm.app" || program == "WezTerm":
+		return PreviewITerm
+	case strings.Contains(term, "sixel") || term == "foot" || strings.HasPrefix(term, "mlterm"):
+		return PreviewSixel
+	}
+	return PreviewANSI
+}
+
+// terminalColumns is the width the ANSI fallback draws at.
+func terminalColumns() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+func printPreview(w io.Writer, mode PreviewMode, img image.Image) error {
+	if mode == PreviewTerm {
+		mode = detectTerminal()
+	}
+	out := bufio.NewWriter(w)
+	var err error
+	switch mode {
+	case PreviewKitty:
+		err = writeKitty(out, img)
+	case PreviewITerm:
+		err = writeITerm(out, img)
+	case PreviewSixel:
+		writeSixel(out, img)
+	default:
+		writeANSI(out, img, terminalColumns())
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	return out.Flush()
+}
+
+func writeKitty(w *bufio.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	for first := true; len(data) > 0; first = false {
+		chunk := data
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		data = data[len(chunk):]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return nil
+}
+
+func writeITerm(w *bufio.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a",
+		buf.Len(), base64.StdEncoding.EncodeToString(buf.Bytes()))
+	return nil
+}
+
+// writeSixel dithers img to the 216-color web-safe palette and writes it
+// six rows at a time, one run-length encoded pass per color in the band.
+func writeSixel(w *bufio.Writer, img image.Image) {
+	if Width(img) > maxSixelWidth {
+		img = resize.Resize(maxSixelWidth, 0, unwrap(img), resize.Lanczos3)
+	}
+	b := img.Bounds()
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.WebSafe)
+	draw.FloydSteinberg.Draw(p, p.Bounds(), img, b.Min)
+
+	fmt.Fprintf(w, "\x1bPq\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i, c := range p.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(w, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	bands := make([][]byte, len(p.Palette))
+	for y := 0; y < b.Dy(); y += 6 {
+		used := make([]bool, len(p.Palette))
+		for i := range bands {
+			bands[i] = bands[i][:0]
+		}
+		for x := 0; x < b.Dx(); x++ {
+			for dy := 0; dy < 6 && y+dy < b.Dy(); dy++ {
+				used[p.ColorIndexAt(x, y+dy)] = true
+			}
+		}
+		for i := range p.Palette {
+			if !used[i] {
+				continue
+			}
+			for x := 0; x < b.Dx(); x++ {
+				bits := byte(0)
+				for dy := 0; dy < 6 && y+dy < b.Dy(); dy++ {
+					if int(p.ColorIndexAt(x, y+dy)) == i {
+						bits |= 1 << uint(dy)
+					}
+				}
+				bands[i] = append(bands[i], '?'+bits)
+			}
+			fmt.Fprintf(w, "#%d", i)
+			writeSixelRuns(w, bands[i])
+			w.WriteByte('$')
+		}
+		w.WriteByte('-')
+	}
+	w.WriteString("\x1b\\")
+}
+
+func writeSixelRuns(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			w.Write(row[i:j])
+		}
+		i = j
+	}
+}
+
+// writeANSI draws two pixel rows per character with the upper half block,
+// foreground for the top pixel and background for the bottom one, in
+// 24-bit color.
+func writeANSI(w *bufio.Writer, img image.Image, columns int) {
+	if Width(img) > columns {
+		img = resize.Resize(uint(columns), 0, unwrap(img), resize.Lanczos3)
+	}
+	b := img.Bounds()
+	rgb := func(x, y int) (uint8, uint8, uint8) {
+		if y >= b.Max.Y {
+			return 0, 0, 0
+		}
+		c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+		return c.R, c.G, c.B
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			tr, tg, tb := rgb(x, y)
+			br, bg, bb := rgb(x, y+1)
+			fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", tr, tg, tb, br, bg, bb)
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PaperSize is a print size in millimeters, portrait.
+type PaperSize struct {
+	width  float64
+	height float64
+}
+
+var paperSizes = map[string]PaperSize{
+	"a3":      {297, 420},
+	"a4":      {210, 297},
+	"a5":      {148, 210},
+	"a6":      {105, 148},
+	"letter":  {215.9, 279.4},
+	"legal":   {215.9, 355.6},
+	"tabloid": {279.4, 431.8},
+	"4x6":     {101.6, 152.4},
+	"5x7":     {127, 177.8},
+	"8x10":    {203.2, 254},
+}
+
+var customPaperSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)x(\d+(?:\.\d+)?)(mm|cm|in)$`)
+
+// parsePaperSize accepts a named size (A3-A6, Letter, Legal, Tabloid, 4x6,
+// 5x7, 8x10) or a custom one such as 100x150mm, 10x15cm or 6x9in.
+func parsePaperSize(s string) (PaperSize, error) {
+	s = strings.ToLower(s)
+	if size, ok := paperSizes[s]; ok {
+		return size, nil
+	}
+	m := customPaperSize.FindStringSubmatch(s)
+	if m == nil {
+		return PaperSize{}, fmt.Errorf("unknown print size %q; use A3-A6, Letter, Legal, Tabloid, 4x6, 5x7, 8x10 or WxH with mm, cm or in", s)
+	}
+	w, _ := strconv.ParseFloat(m[1], 64)
+	h, _ := strconv.ParseFloat(m[2], 64)
+	if w == 0 || h == 0 {
+		return PaperSize{}, fmt.Errorf("print size %q has a zero side", s)
+	}
+	unit := map[string]float64{"mm": 1, "cm": 10, "in": 25.4}[m[3]]
+	return PaperSize{w * unit, h * unit}, nil
+}
+
+func (p PaperSize) landscape() PaperSize {
+	return PaperSize{p.height, p.width}
+}
+
+// pixels is the size of the paper at dpi dots per inch.
+func (p PaperSize) pixels(dpi int) (int, int) {
+	return int(math.Round(p.width / 25.4 * float64(dpi))), int(math.Round(p.height / 25.4 * float64(dpi)))
+}
+
+// pageInsets is the space kept clear at the top and bottom of the paper:
+// the margin, or the safe zone where that is wider.
+func (o Options) pageInsets() (int, int) {
+	top, bottom := o.margin, o.margin
+	if o.safeTop > top {
+		top = o.safeTop
+	}
+	if o.safeBottom > bottom {
+		bottom = o.safeBottom
+	}
+	return top, bottom
+}
+
+// printPage centers page on the paper between the margins and safe zones,
+// filled with the empty color or white, scaling it down first when it does
+// not fit. Without a print size the page is returned as is.
+func (o Options) printPage(page *MyImage) *MyImage {
+	if o.paper == (image.Point{}) {
+		return page
+	}
+	width, height := o.paper.X, o.paper.Y
+	top, bottom := o.pageInsets()
+	fitWidth, fitHeight := width-2*o.margin, height-top-bottom
+	var img image.Image = page.value
+	if scale := math.Min(float64(fitWidth)/float64(Width(img)), float64(fitHeight)/float64(Height(img))); scale < 1 {
+		img = resample(img, uint(math.Max(1, math.Floor(float64(Width(img))*scale))), uint(math.Max(1, math.Floor(float64(Height(img))*scale))))
+	}
+
+	var bg color.Color = color.White
+	if o.emptyColor != nil {
+		bg = o.emptyColor
+	}
+	sheet := o.newCanvas(image.Rect(0, 0, width, height))
+	draw.Draw(sheet.value, sheet.value.Bounds(), image.NewUniform(bg), image.ZP, draw.Src)
+	at := image.Pt((width-Width(img))/2, top+(fitHeight-Height(img))/2)
+	draw.Draw(sheet.value, img.Bounds().Sub(img.Bounds().Min).Add(at), img, img.Bounds().Min, draw.Over)
+	return &sheet
+}
+
+// setDPI records the print resolution in encoded image data: a pHYs chunk
+// for PNG, a JFIF header for JPEG, the resolution tags for TIFF and the
+// header's pixels per meter for BMP. The standard encoders write none, or
+// TIFF's 72 dpi placeholder.
+func setDPI(format string, data []byte, dpi int) ([]byte, error) {
+	switch format {
+	case ".png":
+		return setPNGDPI(data, dpi)
+	case ".jpg", ".jpeg":
+		return setJPEGDPI(data, dpi)
+	case ".tif", ".tiff":
+		return setTIFFDPI(data, dpi)
+	case ".bmp":
+		return setBMPDPI(data, dpi)
+	}
+	return data, nil
+}
+
+// setBMPDPI fills in the pixels per meter of the BITMAPINFOHEADER.
+func setBMPDPI(data []byte, dpi int) ([]byte, error) {
+	if len(data) < 14+40 || string(data[:2]) != "BM" {
+		return nil, errors.New("bmp: no BITMAPINFOHEADER")
+	}
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	out := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(out[38:], ppm)
+	binary.LittleEndian.PutUint32(out[42:], ppm)
+	return out, nil
+}
+
+// setPNGDPI inserts a pHYs chunk after IHDR, in pixels per meter.
+func setPNGDPI(data []byte, dpi int) ([]byte, error) {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("png: no IHDR chunk")
+	}
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], ppm)
+	binary.BigEndian.PutUint32(chunk[12:], ppm)
+	chunk[16] = 1 // unit: meter
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+// setJPEGDPI inserts a JFIF APP0 segment after SOI, in dots per inch, or
+// updates the one jpegtran writes.
+func setJPEGDPI(data []byte, dpi int) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, errors.New("jpeg: no SOI marker")
+	}
+	if dpi > math.MaxUint16 {
+		dpi = math.MaxUint16
+	}
+	if len(data) >= 18 && bytes.Equal(data[2:4], []byte{0xff, 0xe0}) && string(data[6:11]) == "JFIF\x00" {
+		out := append([]byte(nil), data...)
+		out[13] = 1
+		binary.BigEndian.PutUint16(out[14:], uint16(dpi))
+		binary.BigEndian.PutUint16(out[16:], uint16(dpi))
+		return out, nil
+	}
+	app0 := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 2, 1, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(app0[12:], uint16(dpi))
+	binary.BigEndian.PutUint16(app0[14:], uint16(dpi))
+
+	out := make([]byte, 0, len(data)+len(app0))
+	out = append(out, data[:2]...)
+	out = append(out, app0...)
+	return append(out, data[2:]...), nil
+}
+
+// setTIFFDPI rewrites the XResolution and YResolution rationals of the
+// first IFD in place and sets ResolutionUnit to inches.
+func setTIFFDPI(data []byte, dpi int) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, errors.New("tiff: short header")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("tiff: bad byte order")
+	}
+	ifd := int(order.Uint32(data[4:]))
+	if ifd+2 > len(data) {
+		return nil, errors.New("tiff: bad IFD offset")
+	}
+	out := append([]byte(nil), data...)
+	n := int(order.Uint16(out[ifd:]))
+	for i := 0; i < n; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(out) {
+			return nil, errors.New("tiff: truncated IFD")
+		}
+		switch order.Uint16(out[entry:]) {
+		case 282, 283: // XResolution, YResolution
+			at := int(order.Uint32(out[entry+8:]))
+			if at+8 > len(out) {
+				return nil, errors.New("tiff: bad resolution offset")
+			}
+			order.PutUint32(out[at:], uint32(dpi))
+			order.PutUint32(out[at+4:], 1)
+		case 296: // ResolutionUnit
+			order.PutUint16(out[entry+8:], 2)
+		}
+	}
+	return out, nil
+}
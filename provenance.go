@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// version is reported in the metadata of every collage. Release builds set
+// it with -ldflags "-X main.version=1.2.0".
+var version = "dev"
+
+// Provenance records how a collage was made so its inputs can be traced
+// later. It has no timestamps, so the same run writes the same file.
+type Provenance struct {
+	layout string
+	// params are the flags set on the command line as -name=value, in
+	// name order, and args the positional arguments as given.
+	params []string
+	args   []string
+	// sources are the arguments that name local files, with their hashes.
+	sources []Source
+}
+
+// Source is an input file and the hex SHA-256 of its contents.
+type Source struct {
+	name   string
+	sha256 string
+}
+
+// newProvenance collects the flags set on fs and hashes the arguments that
+// are regular files. Remote objects and stdin are listed without a hash.
+// The -o path is left out, so a collage is the same wherever it is written.
+func newProvenance(fs *flag.FlagSet, layout string, args []string) (*Provenance, error) {
+	var params []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "o" {
+			params = append(params, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return hashProvenance(layout, params, args)
+}
+
+// hashProvenance records a run of layout with params and args, hashing the
+// arguments that are regular files as they are now.
+func hashProvenance(layout string, params []string, args []string) (*Provenance, error) {
+	p := &Provenance{layout: layout, params: params, args: args}
+	for _, arg := range args {
+		if fi, err := os.Stat(arg); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		sum, err := hashFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		p.sources = append(p.sources, Source{arg, sum})
+	}
+	return p, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (p *Provenance) software() string {
+	return "imagecollager " + version
+}
+
+// xmp is the record as an XMP packet, readable with exiftool -xmp:all.
+func (p *Provenance) xmp() []byte {
+	var b bytes.Buffer
+	text := func(s string) string {
+		var e bytes.Buffer
+		xml.EscapeText(&e, []byte(s))
+		return e.String()
+	}
+	seq := func(name string, items []string) {
+		fmt.Fprintf(&b, "   <ic:%s><rdf:Seq>\n", name)
+		for _, s := range items {
+			fmt.Fprintf(&b, "    <rdf:li>%s</rdf:li>\n", text(s))
+		}
+		fmt.Fprintf(&b, "   </rdf:Seq></ic:%s>\n", name)
+	}
+
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\" xmlns:ic=\"https://github.com/duffiye/imagecollager/ns/1.0/\">\n")
+	fmt.Fprintf(&b, "   <xmp:CreatorTool>%s</xmp:CreatorTool>\n", text(p.software()))
+	fmt.Fprintf(&b, "   <ic:Layout>%s</ic:Layout>\n", text(p.layout))
+	seq("Parameters", p.params)
+	seq("Arguments", p.args)
+	b.WriteString("   <ic:Sources><rdf:Seq>\n")
+	for _, s := range p.sources {
+		fmt.Fprintf(&b, "    <rdf:li rdf:parseType=\"Resource\"><ic:Name>%s</ic:Name><ic:SHA256>%s</ic:SHA256></rdf:li>\n", text(s.name), s.sha256)
+	}
+	b.WriteString("   </rdf:Seq></ic:Sources>\n")
+	b.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"r\"?>")
+	return b.Bytes()
+}
+
+// setMetadata embeds the record in an encoded image: as XMP in an iTXt
+// chunk plus a Software tEXt chunk in PNG, an APP1 segment in JPEG and the
+// XMP and Software tags in TIFF.
+func setMetadata(format string, data []byte, p *Provenance) ([]byte, error) {
+	switch format {
+	case ".png":
+		return setPNGMetadata(data, p)
+	case ".jpg", ".jpeg":
+		return setJPEGMetadata(data, p)
+	case ".tif", ".tiff":
+		return setTIFFMetadata(data, p)
+	}
+	return data, nil
+}
+
+func pngChunk(typ string, body []byte) []byte {
+	chunk := make([]byte, 8, 12+len(body))
+	binary.BigEndian.PutUint32(chunk, uint32(len(body)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, body...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// setPNGMetadata inserts the chunks after IHDR.
+func setPNGMetadata(data []byte, p *Provenance) ([]byte, error) {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("png: no IHDR chunk")
+	}
+	// An uncompressed iTXt chunk with no language tag.
+	itxt := append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), p.xmp()...)
+	chunks := append(pngChunk("tEXt", []byte("Software\x00"+p.software())), pngChunk("iTXt", itxt)...)
+
+	out := make([]byte, 0, len(data)+len(chunks))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+// setJPEGMetadata inserts the XMP segment after SOI and the JFIF APP0
+// segment, if there is one.
+func setJPEGMetadata(data []byte, p *Provenance) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, errors.New("jpeg: no SOI marker")
+	}
+	at := 2
+	if len(data) >= 6 && bytes.Equal(data[2:4], []byte{0xff, 0xe0}) {
+		at = 4 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+	body := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), p.xmp()...)
+	if len(body)+2 > 0xffff {
+		return nil, errors.New("jpeg: metadata does not fit in one XMP segment; use -no-metadata")
+	}
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(body)+2))
+	app1 = append(app1, body...)
+
+	out := make([]byte, 0, len(data)+len(app1))
+	out = append(out, data[:at]...)
+	out = append(out, app1...)
+	return append(out, data[at:]...), nil
+}
+
+// setTIFFMetadata writes a copy of the first IFD with the Software and XMP
+// tags added at the end of the file and points the header at it. The
+// values of the old entries stay where they are.
+func setTIFFMetadata(data []byte, p *Provenance) ([]byte, error) {
+	const tagSoftware, tagXMP = 305, 700
+	if len(data) < 8 {
+		return nil, errors.New("tiff: short header")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("tiff: bad byte order")
+	}
+	ifd := int(order.Uint32(data[4:]))
+	if ifd+2 > len(data) {
+		return nil, errors.New("tiff: bad IFD offset")
+	}
+	n := int(order.Uint16(data[ifd:]))
+	if ifd+2+12*n+4 > len(data) {
+		return nil, errors.New("tiff: truncated IFD")
+	}
+
+	out := append([]byte(nil), data...)
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	// Values longer than four bytes go before the new IFD, word aligned.
+	value := func(b []byte) uint32 {
+		at := uint32(len(out))
+		out = append(out, b...)
+		if len(out)%2 == 1 {
+			out = append(out, 0)
+		}
+		return at
+	}
+	entries := map[uint16][]byte{}
+	for i := 0; i < n; i++ {
+		entry := data[ifd+2+12*i : ifd+2+12*(i+1)]
+		entries[order.Uint16(entry)] = entry
+	}
+	add := func(tag uint16, typ uint16, b []byte) {
+		entry := make([]byte, 12)
+		order.PutUint16(entry, tag)
+		order.PutUint16(entry[2:], typ)
+		order.PutUint32(entry[4:], uint32(len(b)))
+		order.PutUint32(entry[8:], value(b))
+		entries[tag] = entry
+	}
+	add(tagSoftware, 2, append([]byte(p.software()), 0)) // ASCII
+	add(tagXMP, 1, p.xmp())                              // BYTE
+
+	tags := make([]int, 0, len(entries))
+	for tag := range entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+	order.PutUint32(out[4:], uint32(len(out)))
+	count := make([]byte, 2)
+	order.PutUint16(count, uint16(len(tags)))
+	out = append(out, count...)
+	for _, tag := range tags {
+		out = append(out, entries[uint16(tag)]...)
+	}
+	return append(out, data[ifd+2+12*n:ifd+2+12*n+4]...), nil
+}
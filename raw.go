@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/tiff"
+)
+
+var rawExtensions = map[string]bool{
+	".arw": true, ".cr2": true, ".dng": true, ".nef": true,
+	".nrw": true, ".orf": true, ".raf": true, ".rw2": true,
+}
+
+// maxPreviewCandidates bounds how many embedded JPEG start markers are tried
+// when looking for a preview, since raw sensor data can contain false hits.
+const maxPreviewCandidates = 32
+
+type RawMode string
+
+const (
+	RawAuto    RawMode = "auto"
+	RawDecode  RawMode = "decode"
+	RawPreview RawMode = "preview"
+)
+
+func isRaw(path string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// developRaw demosaics a raw file with dcraw, or libraw's dcraw_emu, using
+// the camera white balance and reading the TIFF result from stdout.
+func developRaw(path string) (image.Image, error) {
+	var cmd *exec.Cmd
+	if p, err := exec.LookPath("dcraw"); err == nil {
+		cmd = exec.Command(p, "-c", "-w", "-T", path)
+	} else if p, err := exec.LookPath("dcraw_emu"); err == nil {
+		cmd = exec.Command(p, "-w", "-T", "-Z", "-", path)
+	} else {
+		return nil, errors.New("raw: neither dcraw nor dcraw_emu found in PATH")
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New("raw: " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	return tiff.Decode(bytes.NewReader(out))
+}
+
+// rawPreview returns the largest JPEG embedded in a raw file. Every raw
+// format in rawExtensions carries at least one full-size or near full-size
+// preview for the camera's own display.
+func rawPreview(data []byte) (image.Image, error) {
+	var best image.Image
+	marker := []byte{0xff, 0xd8, 0xff}
+	for i, tries := 0, 0; tries < maxPreviewCandidates; tries++ {
+		j := bytes.Index(data[i:], marker)
+		if j < 0 {
+			break
+		}
+		i += j
+		if img, err := decodeJPEG(data[i:]); err == nil {
+			if best == nil || Width(img)*Height(img) > Width(best)*Height(best) {
+				best = img
+			}
+		}
+		i += len(marker)
+	}
+
+	if best == nil {
+		return nil, errors.New("raw: no embedded preview found")
+	}
+	return best, nil
+}
+
+// decodeRaw develops the raw file when a decoder is installed, falling back
+// to the embedded preview unless mode insists on one or the other. The
+// zero mode behaves like RawAuto.
+func decodeRaw(path string, data []byte, mode RawMode) (image.Image, error) {
+	if mode != RawPreview {
+		img, err := developRaw(path)
+		if err == nil || mode == RawDecode {
+			return img, err
+		}
+	}
+	return rawPreview(data)
+}
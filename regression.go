@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+type RegressionCase struct {
+	name     string
+	expected image.Image
+	actual   image.Image
+}
+
+type RegressionResult struct {
+	RegressionCase
+	diff    *image.RGBA
+	changed float64
+	passed  bool
+}
+
+func regressionCases(paths []string, images []image.Image, sep string) []RegressionCase {
+	groups, _ := groupVariants(paths, images, sep)
+	cases := make([]RegressionCase, 0, len(groups))
+	for _, g := range groups {
+		cases = append(cases, RegressionCase{g.name, g.variants["expected"], g.variants["actual"]})
+	}
+	return cases
+}
+
+// heatColor maps a normalized difference onto black, red, yellow, white.
+func heatColor(d float64) color.RGBA {
+	switch {
+	case d < 1.0/3:
+		return color.RGBA{uint8(255 * clamp01(d*3)), 0, 0, 255}
+	case d < 2.0/3:
+		return color.RGBA{255, uint8(255 * clamp01(d*3-1)), 0, 255}
+	}
+	return color.RGBA{255, 255, uint8(255 * clamp01(d*3-2)), 255}
+}
+
+func clamp01(v float64) float64 {
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// diffImage compares a and b pixel by pixel over the union of their sizes.
+// Channel differences up to tolerance (0-255) are ignored; pixels present in
+// only one image count as fully different. It returns the heatmap and the
+// fraction of pixels that differ.
+func diffImage(a image.Image, b image.Image, tolerance int) (*image.RGBA, float64) {
+	w, h := Width(a), Height(a)
+	if Width(b) > w {
+		w = Width(b)
+	}
+	if Height(b) > h {
+		h = Height(b)
+	}
+
+	heat := image.NewRGBA(image.Rect(0, 0, w, h))
+	ra, rb := image.Rect(0, 0, Width(a), Height(a)), image.Rect(0, 0, Width(b), Height(b))
+	changed := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := image.Point{x, y}
+			if !p.In(ra) || !p.In(rb) {
+				heat.SetRGBA(x, y, heatColor(1))
+				changed++
+				continue
+			}
+
+			r1, g1, b1, a1 := a.At(a.Bounds().Min.X+x, a.Bounds().Min.Y+y).RGBA()
+			r2, g2, b2, a2 := b.At(b.Bounds().Min.X+x, b.Bounds().Min.Y+y).RGBA()
+			d := 0
+			for _, c := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+				delta := int(c[0]>>8) - int(c[1]>>8)
+				if delta < 0 {
+					delta = -delta
+				}
+				if delta > d {
+					d = delta
+				}
+			}
+			if d > tolerance {
+				changed++
+				heat.SetRGBA(x, y, heatColor(float64(d)/255))
+			} else {
+				heat.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	return heat, float64(changed) / float64(w*h)
+}
+
+func runRegression(cases []RegressionCase, tolerance int, maxChanged float64) []RegressionResult {
+	results := make([]RegressionResult, len(cases))
+	for i, c := range cases {
+		results[i].RegressionCase = c
+		if c.expected == nil || c.actual == nil {
+			continue
+		}
+		results[i].diff, results[i].changed = diffImage(c.expected, c.actual, tolerance)
+		results[i].passed = results[i].changed <= maxChanged
+	}
+	return results
+}
+
+// makeRegressionReport renders one expected | actual | diff row per case
+// under a header with the case name and a pass/fail badge.
+func makeRegressionReport(opts Options, results []RegressionResult) *MyImage {
+	gap := 4
+	headerHeight := labelHeight + 8
+	col := (opts.width - 4*gap) / 3
+
+	rows := make([][]image.Image, len(results))
+	heights := make([]int, len(results))
+	totalHeight := gap
+	for r, res := range results {
+		images := []image.Image{res.expected, res.actual}
+		if res.diff != nil {
+			images = append(images, res.diff)
+		}
+		for _, img := range images {
+			var tile image.Image
+			if img != nil {
+				tile = resizeWidth(img, col)
+				if Height(tile) > heights[r] {
+					heights[r] = Height(tile)
+				}
+			}
+			rows[r] = append(rows[r], tile)
+		}
+		totalHeight += headerHeight + heights[r] + gap
+	}
+
+	output := opts.newCanvas(image.Rect(0, 0, opts.width, totalHeight))
+	pass := image.NewUniform(color.RGBA{44, 160, 44, 255})
+	fail := image.NewUniform(color.RGBA{214, 39, 40, 255})
+
+	sp_y := gap
+	for r, res := range results {
+		badge, src := "PASS", pass
+		switch {
+		case res.diff == nil:
+			badge, src = "MISSING", fail
+		case !res.passed:
+			badge, src = "FAIL", fail
+		}
+		if res.diff != nil {
+			badge += fmt.Sprintf(" %.2f%%", res.changed*100)
+		}
+
+		box := image.Rect(gap, sp_y, gap+textWidth(badge)+8, sp_y+labelHeight+4)
+		draw.Draw(&output, box, src, image.ZP, draw.Src)
+		output.drawString(badge, box.Min.X+4, box.Min.Y+2+labelFace.Ascent, color.White)
+		output.drawString(res.name, box.Max.X+8, box.Min.Y+2+labelFace.Ascent, color.White)
+		sp_y += headerHeight
+
+		for c, tile := range rows[r] {
+			if tile != nil {
+				output.drawRaw(tile, image.Point{gap + c*(col+gap), sp_y})
+			}
+		}
+		sp_y += heights[r] + gap
+	}
+
+	return &output
+}
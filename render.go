@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// renderForm maps the option fields of the web UI and the JS binding onto
+// the same options and layout functions the command line uses.
+func renderForm(form url.Values, images []image.Image) (*MyImage, error) {
+	if len(images) == 0 {
+		return nil, errors.New("no images uploaded")
+	}
+
+	filter, err := parseFilter(form.Get("filter"))
+	if err != nil {
+		return nil, err
+	}
+	normalize, err := parseNormalization(form.Get("normalize"))
+	if err != nil {
+		return nil, err
+	}
+	opts := Options{width: 800, height: 800, shape: ImageShape(form.Get("shape")), filter: filter, normalize: normalize}
+	if opts.shape == "" {
+		opts.shape = RectangleShape
+	}
+	images = normalizeTiles(images, opts.normalize, opts.deep)
+	for i, img := range images {
+		images[i] = opts.filterTile(img)
+	}
+	if width, err := strconv.Atoi(form.Get("width")); err == nil && width > 0 {
+		opts.width, opts.height = width, width
+	}
+	if padding, err := strconv.Atoi(form.Get("padding")); err == nil && padding >= 0 {
+		opts.padding, opts.paddingSet = padding, true
+	}
+
+	switch form.Get("layout") {
+	case "", "grid":
+		if opts.shape != RectangleShape && opts.shape != CircleShape {
+			return nil, errors.New("unknown shape " + strconv.Quote(string(opts.shape)))
+		}
+		rows, err := strconv.Atoi(form.Get("rows"))
+		if err != nil || rows < 1 {
+			rows = int(math.Max(1, math.Round(math.Sqrt(float64(len(images))))))
+		}
+		if err := validateRows(rows, len(images)); err != nil {
+			return nil, err
+		}
+		opts.rows = rows
+		if opts.pack, err = parsePackStrategy(form.Get("pack")); err != nil {
+			return nil, err
+		}
+
+		// The same default seed as the command line, so both render the
+		// same collage from the same options.
+		seed, err := strconv.ParseInt(form.Get("seed"), 10, 64)
+		if err != nil {
+			seed = 1
+		}
+		opts.rotations, err = tileAngles(form.Get("rotate"), seed, images)
+		if err != nil {
+			return nil, err
+		}
+		return renderLayout(opts, "grid", images)
+	case "text":
+		f, err := loadFont("")
+		if err != nil {
+			return nil, err
+		}
+		shape, err := textMask(form.Get("text"), f, opts.width)
+		if err != nil {
+			return nil, err
+		}
+		return makeMaskCollage(opts, shape, images...), nil
+	case "compare":
+		n, err := strconv.Atoi(form.Get("rows"))
+		if err != nil || n < 1 {
+			n = 2
+		}
+		names := make([]string, len(images))
+		for i := range names {
+			names[i] = strconv.Itoa(i + 1)
+		}
+		return makeComparisonSheet(opts, pairByOrder(names, images, n), true, false), nil
+	}
+	if isRegisteredLayout(form.Get("layout")) {
+		if columns, err := strconv.Atoi(form.Get("columns")); err == nil && columns > 0 {
+			opts.columns = columns
+		}
+		return renderLayout(opts, form.Get("layout"), images)
+	}
+	return nil, errors.New("unknown layout " + strconv.Quote(form.Get("layout")))
+}
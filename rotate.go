@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// rotatedSize is the bounding box of a width x height rectangle rotated by
+// degrees.
+func rotatedSize(width int, height int, degrees float64) (int, int) {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	sin, cos = math.Abs(sin), math.Abs(cos)
+	w, h := float64(width), float64(height)
+	return int(math.Ceil(w*cos + h*sin)), int(math.Ceil(w*sin + h*cos))
+}
+
+// rotateImage rotates img clockwise by degrees into an expanded, transparent
+// canvas. The source is padded with a transparent border first so that
+// bilinear sampling fades the edges out instead of leaving jaggies.
+func rotateImage(img image.Image, degrees float64) *image.RGBA64 {
+	const border = 2
+	b := img.Bounds()
+	padded := image.NewRGBA64(image.Rect(0, 0, b.Dx()+2*border, b.Dy()+2*border))
+	draw.Draw(padded, image.Rect(border, border, border+b.Dx(), border+b.Dy()), img, b.Min, draw.Src)
+
+	w, h := rotatedSize(padded.Bounds().Dx(), padded.Bounds().Dy(), degrees)
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	cx, cy := float64(padded.Bounds().Dx())/2, float64(padded.Bounds().Dy())/2
+	dx, dy := float64(w)/2, float64(h)/2
+	m := f64.Aff3{
+		cos, -sin, dx - cos*cx + sin*cy,
+		sin, cos, dy - sin*cx - cos*cy,
+	}
+	xdraw.BiLinear.Transform(dst, m, padded, padded.Bounds(), xdraw.Over, nil)
+	return dst
+}
+
+// tileAngles assigns a rotation to each image in input order. spec is a
+// single angle ("5"), one angle per image ("5,-3,8", cycled), or a range
+// ("-8:8") from which angles are drawn with the given seed.
+func tileAngles(spec string, seed int64, images []image.Image) (map[image.Image]float64, error) {
+	angles := make(map[image.Image]float64, len(images))
+	if spec == "" {
+		return angles, nil
+	}
+
+	if i := strings.Index(spec[1:], ":"); i >= 0 {
+		lo, errLo := strconv.ParseFloat(spec[:i+1], 64)
+		hi, errHi := strconv.ParseFloat(spec[i+2:], 64)
+		if errLo != nil || errHi != nil {
+			return nil, fmt.Errorf("invalid rotation range %q, expected min:max", spec)
+		}
+
+		rng := rand.New(rand.NewSource(seed))
+		for _, img := range images {
+			angles[img] = lo + rng.Float64()*(hi-lo)
+		}
+		return angles, nil
+	}
+
+	var list []float64
+	for _, s := range strings.Split(spec, ",") {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotation angle %q", s)
+		}
+		list = append(list, v)
+	}
+	for i, img := range images {
+		angles[img] = list[i%len(list)]
+	}
+	return angles, nil
+}
@@ -0,0 +1,22 @@
+//go:build !js && !windows
+// +build !js,!windows
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRSS is the process's maximum resident set size in bytes.
+func peakRSS() (uint64, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	// Linux and the BSDs report kilobytes, macOS bytes.
+	if runtime.GOOS == "darwin" {
+		return uint64(ru.Maxrss), true
+	}
+	return uint64(ru.Maxrss) * 1024, true
+}
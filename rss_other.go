@@ -0,0 +1,9 @@
+//go:build js || windows
+// +build js windows
+
+package main
+
+// peakRSS is not measured on this platform.
+func peakRSS() (uint64, bool) {
+	return 0, false
+}
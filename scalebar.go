@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+)
+
+const (
+	scaleBarGap       = 3
+	scaleBarThickness = 8
+	scaleBarHeight    = scaleBarGap + scaleBarThickness + 2 + labelHeight
+)
+
+type valueRanger interface {
+	ValueRange() (float64, float64)
+}
+
+// intensityRange reports the values that black and white stand for in img:
+// the physical data range when the decoder knows it, otherwise the range of
+// the stored samples.
+func intensityRange(img image.Image) (float64, float64) {
+	if r, ok := img.(valueRanger); ok {
+		return r.ValueRange()
+	}
+	if isDeep(img) {
+		return 0, 0xffff
+	}
+	return 0, 0xff
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 5, 64)
+}
+
+func (bgImg *MyImage) drawScaleBar(sp image.Point, width int, lo float64, hi float64) {
+	top := sp.Y + scaleBarGap
+	for x := 0; x < width; x++ {
+		g := uint8(0)
+		if width > 1 {
+			g = uint8(255 * x / (width - 1))
+		}
+		for y := top; y < top+scaleBarThickness; y++ {
+			bgImg.Set(sp.X+x, y, color.Gray{g})
+		}
+	}
+
+	baseline := top + scaleBarThickness + 2 + labelFace.Ascent
+	bgImg.drawString(formatValue(lo), sp.X, baseline, color.White)
+
+	hiLabel := formatValue(hi)
+	bgImg.drawString(hiLabel, sp.X+width-textWidth(hiLabel), baseline, color.White)
+}
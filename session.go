@@ -0,0 +1,95 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Session is everything needed to pick a collage project up again: the
+// uploaded images in their current order, with their original bytes, and
+// the UI options. It is independent of any exported image.
+type Session struct {
+	Options map[string]string `json:"options"`
+	Images  []SessionImage    `json:"images"`
+}
+
+type SessionImage struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+func loadSession(path string) (*Session, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// saveSession writes to a temporary file first so that a crash mid-write
+// never leaves a truncated session behind.
+func saveSession(path string, s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restore decodes the session images into the server, replacing its state.
+func (s *uiServer) restore(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.images = nil
+	for _, si := range session.Images {
+		img, err := decodeImage(si.Data)
+		if err != nil {
+			return err
+		}
+		s.nextID++
+		s.images = append(s.images, uploadedImage{s.nextID, si.Name, img, si.Data})
+	}
+	s.options = session.Options
+	return nil
+}
+
+// persist saves the current state when the UI was started with a session
+// file. The caller must hold s.mu.
+func (s *uiServer) persist() error {
+	if s.sessionPath == "" {
+		return nil
+	}
+	session := &Session{Options: s.options}
+	for _, u := range s.images {
+		session.Images = append(session.Images, SessionImage{u.Name, u.data})
+	}
+	return saveSession(s.sessionPath, session)
+}
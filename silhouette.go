@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/nfnt/resize"
+)
+
+// silhouetteMask turns a mask image into an alpha mask. Images with
+// transparency use their alpha channel; fully opaque images (such as a
+// black logo on white) treat dark pixels as the inside of the shape.
+func silhouetteMask(img image.Image) *image.Alpha {
+	b := img.Bounds()
+	mask := image.NewAlpha(image.Rect(0, 0, b.Dx(), b.Dy()))
+
+	opaque := true
+	for y := b.Min.Y; y < b.Max.Y && opaque; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < 0xffff {
+				opaque = false
+				break
+			}
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.At(x, y)
+			a := uint8(0)
+			if opaque {
+				a = 255 - color.GrayModel.Convert(c).(color.Gray).Y
+			} else {
+				_, _, _, a32 := c.RGBA()
+				a = uint8(a32 >> 8)
+			}
+			mask.SetAlpha(x-b.Min.X, y-b.Min.Y, color.Alpha{a})
+		}
+	}
+	return mask
+}
+
+// insideCells returns the cells of a size x size grid that touch mask,
+// sampled on a 5x5 lattice per cell. Tiles are clipped to the mask when
+// drawn, so partly covered cells keep thin strokes and edges intact.
+func insideCells(mask *image.Alpha, size int) []image.Rectangle {
+	var cells []image.Rectangle
+	b := mask.Bounds()
+	for y := b.Min.Y; y+size <= b.Max.Y; y += size {
+		for x := b.Min.X; x+size <= b.Max.X; x += size {
+			inside := false
+			for i := 0; i < 5 && !inside; i++ {
+				for j := 0; j < 5; j++ {
+					if mask.AlphaAt(x+size*(2*i+1)/10, y+size*(2*j+1)/10).A >= 128 {
+						inside = true
+						break
+					}
+				}
+			}
+			if inside {
+				cells = append(cells, image.Rect(x, y, x+size, y+size))
+			}
+		}
+	}
+	return cells
+}
+
+// makeMaskCollage packs images into the opaque region of shape, scaled to
+// the requested canvas width. It picks the largest square cell size that
+// still yields a cell for every image; leftover cells repeat images from
+// the start so the whole silhouette is filled.
+func makeMaskCollage(opts Options, shape image.Image, images ...image.Image) *MyImage {
+	scaled := resize.Resize(uint(opts.width), 0, shape, resize.Bilinear)
+	mask := silhouetteMask(scaled)
+	b := mask.Bounds()
+
+	size := b.Dx()
+	if b.Dy() < size {
+		size = b.Dy()
+	}
+	cells := insideCells(mask, size)
+	for size > 4 && len(cells) < len(images) {
+		size--
+		cells = insideCells(mask, size)
+	}
+
+	output := opts.newCanvas(b)
+	if len(images) == 0 {
+		return &output
+	}
+
+	padding := 1
+	for i, cell := range cells {
+		r := cell.Inset(padding)
+		tile := opts.vignetteTile(coverTile(images[i%len(images)], r.Dx(), r.Dy()), RectangleShape)
+		mode, opacity := opts.tileBlend(nil)
+		blendDraw(&output, r, tile, image.ZP, mask, r.Min, mode, opacity)
+	}
+	return &output
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+)
+
+// SlideshowStyle is how a video export animates a grid collage.
+type SlideshowStyle string
+
+const (
+	// SlideshowAssemble fades the tiles in one by one, in layout order,
+	// onto the empty canvas and holds the finished collage.
+	SlideshowAssemble SlideshowStyle = "assemble"
+	// SlideshowShuffle cross-fades between arrangements of the images in
+	// shuffled orders.
+	SlideshowShuffle SlideshowStyle = "shuffle"
+)
+
+const (
+	slideshowFPS = 30
+	// slideshowArrangements is how many orders the shuffle style shows.
+	slideshowArrangements = 4
+)
+
+// videoCodecs are the ffmpeg encoders of the video outputs.
+var videoCodecs = map[string][]string{
+	".mp4":  {"-c:v", "libx264", "-movflags", "+faststart"},
+	".webm": {"-c:v", "libvpx-vp9", "-b:v", "0", "-crf", "32"},
+}
+
+func isVideoOutput(path string) bool {
+	_, ok := videoCodecs[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+func parseSlideshowStyle(s string) (SlideshowStyle, error) {
+	switch st := SlideshowStyle(s); st {
+	case SlideshowAssemble, SlideshowShuffle:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown video style %q; use assemble or shuffle", s)
+}
+
+// Slideshow animates a grid collage into frames. step is the seconds each
+// tile takes to fade in, or each arrangement is shown for.
+type Slideshow struct {
+	style SlideshowStyle
+	step  float64
+	seed  int64
+}
+
+// keyframes returns how many pages the video fades between and a function
+// rendering each, bordered and fitted to the paper like a saved page.
+func (s Slideshow) keyframes(opts Options, images []image.Image) (int, func(i int) *MyImage) {
+	finish := func(page *MyImage) *MyImage {
+		return opts.printPage(opts.borderPage(page))
+	}
+	if s.style == SlideshowShuffle {
+		opts.keepOrder = true
+		r := rand.New(rand.NewSource(s.seed))
+		orders := make([][]image.Image, slideshowArrangements)
+		order := append([]image.Image(nil), images...)
+		for i := range orders {
+			if i > 0 {
+				r.Shuffle(len(order), func(a, b int) { order[a], order[b] = order[b], order[a] })
+			}
+			orders[i] = append([]image.Image(nil), order...)
+		}
+		return len(orders), func(i int) *MyImage {
+			return finish(makeImageCollage(opts, orders[i]...))
+		}
+	}
+
+	// Place and scale the tiles once; keyframe k draws the first k of
+	// them, with their borders, captions and scale bars.
+	g := drawGrid(opts, append([]image.Image(nil), images...))
+	g.scaleTiles()
+	rank := make(map[image.Image]int)
+	for _, row := range g.plan.rows {
+		for _, c := range row.cells {
+			rank[c.img] = len(rank)
+		}
+	}
+	return len(rank) + 1, func(k int) *MyImage {
+		return finish(g.composite(func(img image.Image) bool { return rank[img] < k }))
+	}
+}
+
+// frames writes the video as raw RGBA frames of size, flattened onto bg,
+// fitting keyframes of other sizes into it.
+func (s Slideshow) frames(w io.Writer, size image.Rectangle, n int, page func(i int) *MyImage, bg color.Color) error {
+	flatten := func(p *MyImage) *image.RGBA {
+		var img image.Image = p.value
+		if scale := math.Min(float64(size.Dx())/float64(Width(img)), float64(size.Dy())/float64(Height(img))); scale < 1 {
+			img = resample(img, uint(math.Max(1, math.Floor(float64(Width(img))*scale))), uint(math.Max(1, math.Floor(float64(Height(img))*scale))))
+		}
+		flat := image.NewRGBA(size)
+		draw.Draw(flat, size, image.NewUniform(bg), image.ZP, draw.Src)
+		at := image.Pt((size.Dx()-Width(img))/2, (size.Dy()-Height(img))/2)
+		draw.Draw(flat, img.Bounds().Sub(img.Bounds().Min).Add(at), img, img.Bounds().Min, draw.Over)
+		return flat
+	}
+
+	// Assembling fades over each whole step; shuffling holds each
+	// arrangement and fades over the last third of its step.
+	fade, hold := int(math.Max(1, math.Round(s.step*slideshowFPS))), 0
+	if s.style == SlideshowShuffle {
+		hold = fade - fade/3
+		fade = fade / 3
+		if fade < 1 {
+			fade = 1
+		}
+	}
+	emit := func(img *image.RGBA, times int) error {
+		for i := 0; i < times; i++ {
+			if _, err := w.Write(img.Pix); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	frame := image.NewRGBA(size)
+	current := flatten(page(0))
+	for i := 1; i < n; i++ {
+		next := flatten(page(i))
+		if err := emit(current, hold); err != nil {
+			return err
+		}
+		for f := 1; f <= fade; f++ {
+			copy(frame.Pix, current.Pix)
+			alpha := color.Alpha{uint8(math.Round(255 * float64(f) / float64(fade)))}
+			draw.DrawMask(frame, size, next, image.ZP, image.NewUniform(alpha), image.ZP, draw.Over)
+			if err := emit(frame, 1); err != nil {
+				return err
+			}
+		}
+		current = next
+	}
+	// Hold the last keyframe for two steps, or a second at least.
+	return emit(current, int(math.Max(slideshowFPS, math.Round(2*s.step*slideshowFPS))))
+}
+
+// writeSlideshow encodes the animated collage to an MP4 or WebM file with
+// ffmpeg, padding odd sizes by a pixel for its chroma subsampling.
+func writeSlideshow(path string, opts Options, images []image.Image, s Slideshow) error {
+	n, page := s.keyframes(opts, images)
+	first := page(0)
+	var bg color.Color = color.White
+	if opts.emptyColor != nil {
+		bg = opts.emptyColor
+	}
+	size := first.Bounds()
+	args := []string{"-v", "error", "-y",
+		"-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", size.Dx(), size.Dy()), "-r", fmt.Sprint(slideshowFPS), "-i", "-",
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2", "-pix_fmt", "yuv420p"}
+	args = append(args, videoCodecs[strings.ToLower(filepath.Ext(path))]...)
+	args = append(args, path)
+	return pipeFFmpeg(func(w io.Writer) error {
+		bw := bufio.NewWriterSize(w, 1<<20)
+		// The first keyframe sized the video already; reuse it.
+		keyframe := func(i int) *MyImage {
+			if i == 0 {
+				return first
+			}
+			return page(i)
+		}
+		if err := s.frames(bw, size, n, keyframe, bg); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}, "ffmpeg", args...)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/draw"
+	"strconv"
+)
+
+// splitImage cuts img into rows x cols tiles in row-major order. Tile edges
+// fall on i*width/cols, so any remainder is spread over the tiles instead
+// of being dropped from the last one.
+func splitImage(img image.Image, rows int, cols int) []image.Image {
+	b := img.Bounds()
+	tiles := make([]image.Image, 0, rows*cols)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			r := image.Rect(b.Min.X+col*b.Dx()/cols, b.Min.Y+row*b.Dy()/rows, b.Min.X+(col+1)*b.Dx()/cols, b.Min.Y+(row+1)*b.Dy()/rows)
+			var tile draw.Image = image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+			if isDeep(img) {
+				tile = image.NewRGBA64(tile.Bounds())
+			}
+			draw.Draw(tile, tile.Bounds(), img, r.Min, draw.Src)
+			tiles = append(tiles, tile)
+		}
+	}
+	return tiles
+}
+
+func splitMain(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	configureLogging := addLogFlags(fs)
+	outputPath := fs.String("o", "tile.png", "output file pattern; tiles are numbered tile-001.png, tile-002.png, ...")
+	raw := fs.String("raw", "auto", "camera raw handling: decode (dcraw/libraw), preview (embedded JPEG) or auto")
+	square := fs.Bool("square", false, "crop the image to a cols:rows aspect first so every tile is square")
+	fs.Parse(args)
+	configureLogging()
+
+	if fs.NArg() != 3 {
+		logger.Fatal("Usage: imagecollager split [flags] <rows> <cols> <image>")
+	}
+	rows, errRows := strconv.Atoi(fs.Arg(0))
+	cols, errCols := strconv.Atoi(fs.Arg(1))
+	if errRows != nil || errCols != nil || rows < 1 || cols < 1 {
+		logger.Fatal("Number of rows and columns must be at least 1")
+	}
+
+	img, err := Options{raw: RawMode(*raw)}.loadImage(fs.Arg(2))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if *square {
+		side := Width(img) / cols
+		if h := Height(img) / rows; h < side {
+			side = h
+		}
+		img = cropCenter(img, side*cols, side*rows)
+	}
+
+	tiles := splitImage(img, rows, cols)
+	for i, tile := range tiles {
+		if err := saveImage(pagePath(*outputPath, i, len(tiles)), tile, Encoding{}); err != nil {
+			logger.Fatal(err)
+		}
+	}
+}
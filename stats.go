@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// statsStages are the stages -stats reports, in pipeline order; time in
+// none of them, such as parsing flags, counts as other.
+var statsStages = []string{"decode", "resize", "layout", "composite", "encode", "other"}
+
+// Stats charges wall time to the stage the program is in. Stages nest: a
+// resize during compositing counts as resize, not composite. Goroutines
+// enter stages concurrently, so every entry stays open until its own exit
+// and time goes to the stage entered last among the open ones.
+type Stats struct {
+	mu      sync.Mutex
+	enabled bool
+	start   time.Time
+	since   time.Time
+	open    []*string
+	stages  map[string]time.Duration
+}
+
+var stats = &Stats{}
+
+func (s *Stats) enable() {
+	s.enabled = true
+	s.start, s.since = time.Now(), time.Now()
+	s.stages = make(map[string]time.Duration)
+}
+
+// enter opens stage and returns the func that closes it, for use as
+// defer stats.enter("resize")().
+func (s *Stats) enter(stage string) func() {
+	if !s.enabled {
+		return func() {}
+	}
+	entry := &stage
+	s.mu.Lock()
+	s.charge()
+	s.open = append(s.open, entry)
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.charge()
+		for i := len(s.open) - 1; i >= 0; i-- {
+			if s.open[i] == entry {
+				s.open = append(s.open[:i], s.open[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// charge adds the time since the last change to the current stage. The
+// caller holds s.mu.
+func (s *Stats) charge() {
+	now := time.Now()
+	current := "other"
+	if len(s.open) > 0 {
+		current = *s.open[len(s.open)-1]
+	}
+	s.stages[current] += now.Sub(s.since)
+	s.since = now
+}
+
+// report writes the time per stage, the total and the peak resident set
+// size as a table, or as one JSON object with durations in seconds.
+func (s *Stats) report(w io.Writer, asJSON bool) error {
+	s.mu.Lock()
+	s.charge()
+	total := time.Since(s.start)
+	stages := make(map[string]time.Duration, len(s.stages))
+	for stage, d := range s.stages {
+		stages[stage] = d
+	}
+	s.mu.Unlock()
+	rss, rssOK := peakRSS()
+
+	if asJSON {
+		out := struct {
+			Stages  map[string]float64 `json:"stages"`
+			Total   float64            `json:"total"`
+			PeakRSS *uint64            `json:"peakRSS,omitempty"`
+		}{Stages: make(map[string]float64), Total: total.Seconds()}
+		for _, stage := range statsStages {
+			out.Stages[stage] = stages[stage].Seconds()
+		}
+		if rssOK {
+			out.PeakRSS = &rss
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, stage := range statsStages {
+		d := stages[stage]
+		fmt.Fprintf(tw, "%s\t%.3fs\t%.1f%%\n", stage, d.Seconds(), 100*d.Seconds()/total.Seconds())
+	}
+	fmt.Fprintf(tw, "total\t%.3fs\n", total.Seconds())
+	if rssOK {
+		fmt.Fprintf(tw, "peak RSS\t%.1f MiB\n", float64(rss)/(1<<20))
+	} else {
+		fmt.Fprintf(tw, "peak RSS\tunavailable\n")
+	}
+	return tw.Flush()
+}
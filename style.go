@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+)
+
+// TileStyle overrides the global options for one grid tile. Rotate and
+// Caption are pointers so that 0 and "" can clear a global value.
+type TileStyle struct {
+	Shape       ImageShape `json:"shape"`
+	Border      int        `json:"border"`
+	BorderColor string     `json:"borderColor"`
+	Rotate      *float64   `json:"rotate"`
+	Caption     *string    `json:"caption"`
+	// Scale weights the tile's share of its row's width: a tile with
+	// scale 2 is twice as wide as its neighbors with the default 1.
+	Scale float64 `json:"scale"`
+	// Weight sizes the tile's cell in the treemap layout and its circle in
+	// the circles layout, overriding a "file.jpg:3" suffix.
+	Weight float64 `json:"weight"`
+	// Blend and Opacity set how the tile mixes with tiles it overlaps.
+	Blend   BlendMode `json:"blend"`
+	Opacity *float64  `json:"opacity"`
+
+	borderColor color.Color
+}
+
+// readTileStyles reads a JSON object of styles keyed by file name (as given
+// or its base name) or by 1-based position among paths, and returns them
+// keyed by the matching images.
+func readTileStyles(path string, paths []string, images []image.Image) (map[image.Image]TileStyle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]TileStyle
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("style: %s: %v", path, err)
+	}
+
+	styles := make(map[image.Image]TileStyle)
+	for key, s := range entries {
+		switch s.Shape {
+		case "", RectangleShape, CircleShape:
+		default:
+			return nil, fmt.Errorf("style: %q: unknown shape %q", key, s.Shape)
+		}
+		if s.Border < 0 || s.Scale < 0 || s.Weight < 0 {
+			return nil, fmt.Errorf("style: %q: border, scale and weight must not be negative", key)
+		}
+		if s.Blend != "" {
+			if _, err := parseBlendMode(string(s.Blend)); err != nil {
+				return nil, fmt.Errorf("style: %q: %v", key, err)
+			}
+		}
+		if s.Opacity != nil && (*s.Opacity < 0 || *s.Opacity > 1) {
+			return nil, fmt.Errorf("style: %q: opacity must be between 0 and 1", key)
+		}
+		s.borderColor = color.White
+		if s.BorderColor != "" {
+			if s.borderColor, err = parseHexColor(s.BorderColor); err != nil {
+				return nil, fmt.Errorf("style: %q: %v", key, err)
+			}
+		}
+
+		matched := false
+		n, err := strconv.Atoi(key)
+		for i, p := range paths {
+			if (err == nil && n == i+1) || key == p || key == filepath.Base(p) {
+				styles[images[i]] = s
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("style: no image matches %q", key)
+		}
+	}
+	return styles, nil
+}
+
+// withTileStyles sets the styles and moves their rotations and captions
+// into the per-tile maps the layout already reads.
+func (o Options) withTileStyles(styles map[image.Image]TileStyle) Options {
+	o.styles = styles
+	for img, s := range styles {
+		if s.Rotate != nil {
+			if o.rotations == nil {
+				o.rotations = make(map[image.Image]float64)
+			}
+			o.rotations[img] = *s.Rotate
+		}
+		if s.Caption != nil {
+			if o.captions == nil {
+				o.captions = make(map[image.Image][]string)
+			}
+			o.captions[img] = []string{*s.Caption}
+		}
+	}
+	return o
+}
+
+func (o Options) tileShape(img image.Image) ImageShape {
+	if s := o.styles[img].Shape; s != "" {
+		return s
+	}
+	return o.shape
+}
+
+// cellWidths splits the collage width between the tiles of a row in
+// proportion to their scale, evenly without styles.
+func (o Options) cellWidths(row []image.Image) []float64 {
+	scales := make([]float64, len(row))
+	total := 0.0
+	for i, img := range row {
+		scales[i] = 1
+		if s := o.styles[img].Scale; s > 0 {
+			scales[i] = s
+		}
+		total += scales[i]
+	}
+	widths := make([]float64, len(row))
+	for i, s := range scales {
+		widths[i] = math.Floor(float64(o.width) * s / total)
+	}
+	return widths
+}
+
+// drawTileBorder draws a tile's styled border inside the tile at r: a
+// frame for rectangles and a ring for circles.
+func (bgImg *MyImage) drawTileBorder(s TileStyle, shape ImageShape, r image.Rectangle) {
+	if s.Border <= 0 {
+		return
+	}
+	c := image.NewUniform(s.borderColor)
+	if shape == CircleShape {
+		ring := &Ring{&Circle{r.Min.Add(image.Pt(r.Dx()/2, r.Dy()/2)), r.Dx() / 2}, r.Dx()/2 - s.Border}
+		draw.DrawMask(bgImg.value, r, c, image.ZP, ring, r.Min, draw.Over)
+		return
+	}
+	inner := r.Inset(s.Border)
+	for _, side := range []image.Rectangle{
+		{r.Min, image.Pt(r.Max.X, inner.Min.Y)},
+		{image.Pt(r.Min.X, inner.Max.Y), r.Max},
+		{image.Pt(r.Min.X, inner.Min.Y), image.Pt(inner.Min.X, inner.Max.Y)},
+		{image.Pt(inner.Max.X, inner.Min.Y), image.Pt(r.Max.X, inner.Max.Y)},
+	} {
+		draw.Draw(bgImg.value, side, c, image.ZP, draw.Over)
+	}
+}
+
+// Ring is a circle mask with a hole of the given radius.
+type Ring struct {
+	*Circle
+	inner int
+}
+
+func (m *Ring) At(x, y int) color.Color {
+	xx, yy, rr := float64(x-m.p.X)+0.5, float64(y-m.p.Y)+0.5, float64(m.inner)
+	if xx*xx+yy*yy < rr*rr {
+		return color.Alpha{0}
+	}
+	return m.Circle.At(x, y)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// defaultSVGSize is the nominal width given to SVGs without a usable viewBox.
+const defaultSVGSize = 512
+
+// vectorImage is a tile that can be rendered at any size, so scaling it
+// means drawing it again rather than resampling pixels.
+type vectorImage interface {
+	image.Image
+	Rasterize(width int, height int) image.Image
+}
+
+// SVGImage is a lazily rasterized SVG document. It reports its viewBox size
+// as its bounds so layouts can compute aspect ratios, and is only drawn at
+// that size if something reads its pixels directly.
+type SVGImage struct {
+	icon   *oksvg.SvgIcon
+	width  int
+	height int
+	pixels image.Image
+	once   sync.Once
+
+	// mu serializes rasterizing, which retargets the shared icon, so tiles
+	// can be scaled in parallel.
+	mu sync.Mutex
+}
+
+func isSVG(path string, data []byte) bool {
+	if strings.ToLower(filepath.Ext(path)) == ".svg" {
+		return true
+	}
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	return bytes.Contains(head, []byte("<svg"))
+}
+
+func decodeSVG(data []byte) (*SVGImage, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	width, height := defaultSVGSize, defaultSVGSize
+	if icon.ViewBox.W > 0 && icon.ViewBox.H > 0 {
+		width = int(math.Ceil(icon.ViewBox.W))
+		height = int(math.Ceil(icon.ViewBox.H))
+	}
+	return &SVGImage{icon: icon, width: width, height: height}, nil
+}
+
+func (s *SVGImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (s *SVGImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.width, s.height)
+}
+
+func (s *SVGImage) At(x, y int) color.Color {
+	s.once.Do(func() {
+		s.pixels = s.Rasterize(s.width, s.height)
+	})
+	return s.pixels.At(x, y)
+}
+
+// Rasterize draws the document stretched to width x height; callers keep the
+// aspect ratio by asking for a size derived from Bounds.
+func (s *SVGImage) Rasterize(width int, height int) image.Image {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rasterize(width, height)
+}
+
+func (s *SVGImage) rasterize(width int, height int) image.Image {
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	s.icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, out, out.Bounds())
+	s.icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return out
+}
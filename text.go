@@ -0,0 +1,27 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var labelFace = basicfont.Face7x13
+
+const labelHeight = 13
+
+func textWidth(s string) int {
+	return font.MeasureString(labelFace, s).Round()
+}
+
+func (bgImg *MyImage) drawString(s string, x int, baseline int, c color.Color) {
+	bgImg.drawText(labelFace, s, x, baseline, c)
+}
+
+func (bgImg *MyImage) drawText(face font.Face, s string, x int, baseline int, c color.Color) {
+	d := font.Drawer{Dst: bgImg, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, baseline)}
+	d.DrawString(s)
+}
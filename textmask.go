@@ -0,0 +1,50 @@
+package main
+
+import (
+	"image"
+	"io/ioutil"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// loadFont parses a TrueType/OpenType file, defaulting to Go Bold whose
+// heavy strokes leave room for photo tiles.
+func loadFont(path string) (*opentype.Font, error) {
+	ttf := gobold.TTF
+	if path != "" {
+		var err error
+		ttf, err = ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opentype.Parse(ttf)
+}
+
+// textMask renders text in f at the size that makes it span width pixels.
+func textMask(text string, f *opentype.Font, width int) (*image.Alpha, error) {
+	const probeSize = 100
+	probe, err := opentype.NewFace(f, &opentype.FaceOptions{Size: probeSize, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	advance := font.MeasureString(probe, text).Ceil()
+	if advance == 0 {
+		return image.NewAlpha(image.Rect(0, 0, width, 1)), nil
+	}
+
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: probeSize * float64(width) / float64(advance), DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	mask := image.NewAlpha(image.Rect(0, 0, width, (metrics.Ascent + metrics.Descent).Ceil()))
+	d := font.Drawer{Dst: mask, Src: image.Opaque, Face: face, Dot: fixed.Point26_6{Y: metrics.Ascent}}
+	d.DrawString(text)
+	return mask, nil
+}
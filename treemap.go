@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// splitWeight separates a "hero.jpg:3" argument into the path and its
+// weight. Arguments without a positive number after the last colon, or
+// naming a file that exists as given, weigh 1.
+func splitWeight(arg string) (string, float64) {
+	i := strings.LastIndex(arg, ":")
+	if i <= 0 {
+		return arg, 1
+	}
+	w, err := strconv.ParseFloat(arg[i+1:], 64)
+	if err != nil || w <= 0 || math.IsInf(w, 0) {
+		return arg, 1
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return arg, 1
+	}
+	return arg[:i], w
+}
+
+// squarify splits a width x height canvas into one rectangle per weight,
+// with areas in proportion to the weights, using the squarified treemap
+// algorithm: cells are laid in strips along the shorter side of the space
+// left, and a strip takes cells for as long as that brings its worst
+// aspect ratio closer to 1. The weights must be sorted heaviest first.
+func squarify(weights []float64, width float64, height float64) [][4]float64 {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	areas := make([]float64, len(weights))
+	for i, w := range weights {
+		areas[i] = w / total * width * height
+	}
+
+	// worst is the largest aspect ratio in a strip of the given areas
+	// along a side of length side.
+	worst := func(strip []float64, side float64) float64 {
+		sum, lo, hi := 0.0, math.Inf(1), 0.0
+		for _, a := range strip {
+			sum += a
+			lo, hi = math.Min(lo, a), math.Max(hi, a)
+		}
+		return math.Max(side*side*hi/(sum*sum), sum*sum/(side*side*lo))
+	}
+
+	cells := make([][4]float64, 0, len(areas))
+	x, y, w, h := 0.0, 0.0, width, height
+	lay := func(strip []float64) {
+		sum := 0.0
+		for _, a := range strip {
+			sum += a
+		}
+		if w >= h {
+			// A column at the left, cells top to bottom.
+			cw, at := sum/h, y
+			for _, a := range strip {
+				cells = append(cells, [4]float64{x, at, x + cw, at + a/cw})
+				at += a / cw
+			}
+			x, w = x+cw, w-cw
+		} else {
+			// A row at the top, cells left to right.
+			ch, at := sum/w, x
+			for _, a := range strip {
+				cells = append(cells, [4]float64{at, y, at + a/ch, y + ch})
+				at += a / ch
+			}
+			y, h = y+ch, h-ch
+		}
+	}
+
+	var strip []float64
+	for _, a := range areas {
+		side := math.Min(w, h)
+		if len(strip) == 0 || worst(append(strip, a), side) <= worst(strip, side) {
+			strip = append(strip, a)
+			continue
+		}
+		lay(strip)
+		strip = []float64{a}
+	}
+	if len(strip) > 0 {
+		lay(strip)
+	}
+	// Summing the areas back up leaves the far edges a hair off the
+	// canvas edge; snap them to it.
+	for i := range cells {
+		if math.Abs(cells[i][2]-width) < 1e-6*width {
+			cells[i][2] = width
+		}
+		if math.Abs(cells[i][3]-height) < 1e-6*height {
+			cells[i][3] = height
+		}
+	}
+	return cells
+}
+
+// makeTreemap gives each image a cell of the opts.width x opts.height
+// canvas with an area in proportion to its weight in opts.weights, 1 by
+// default, and crops the image to cover it. gap pixels separate the cells.
+func makeTreemap(opts Options, images []image.Image, gap int) *MyImage {
+	order := make([]int, len(images))
+	for i := range order {
+		order[i] = i
+	}
+	weight := func(img image.Image) float64 {
+		if w, ok := opts.weights[img]; ok {
+			return w
+		}
+		return 1
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return weight(images[order[i]]) > weight(images[order[j]])
+	})
+	weights := make([]float64, len(order))
+	for k, i := range order {
+		weights[k] = weight(images[i])
+	}
+
+	// Round the cell edges to whole pixels and take half the gap from each
+	// side of the edges cells share, none from the canvas edge.
+	cells := squarify(weights, float64(opts.width), float64(opts.height))
+	boxes := make([]image.Rectangle, len(cells))
+	for k, c := range cells {
+		r := image.Rect(int(math.Round(c[0])), int(math.Round(c[1])), int(math.Round(c[2])), int(math.Round(c[3])))
+		if r.Min.X > 0 {
+			r.Min.X += gap - gap/2
+		}
+		if r.Min.Y > 0 {
+			r.Min.Y += gap - gap/2
+		}
+		if r.Max.X < opts.width {
+			r.Max.X -= gap / 2
+		}
+		if r.Max.Y < opts.height {
+			r.Max.Y -= gap / 2
+		}
+		boxes[k] = r
+	}
+
+	output := opts.newCanvas(image.Rect(0, 0, opts.width, opts.height))
+	tiles := make([]image.Image, len(images))
+	parallel(len(images), largestDecoded(images), func(k int) {
+		if box := boxes[k]; !box.Empty() {
+			tiles[k] = coverTile(images[order[k]], box.Dx(), box.Dy())
+		}
+	})
+	for k, tile := range tiles {
+		if tile != nil {
+			draw.Draw(output.value, boxes[k], tile, tile.Bounds().Min, draw.Src)
+		}
+	}
+	return &output
+}
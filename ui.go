@@ -0,0 +1,326 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+type uploadedImage struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	img  image.Image
+	data []byte
+}
+
+// uiServer keeps uploaded images decoded in memory so that every preview
+// re-renders from the same tiles without re-uploading or re-decoding.
+type uiServer struct {
+	mu          sync.Mutex
+	nextID      int
+	images      []uploadedImage
+	options     map[string]string
+	sessionPath string
+}
+
+func uiMain(args []string) {
+	fs := flag.NewFlagSet("ui", flag.ExitOnError)
+	configureLogging := addLogFlags(fs)
+	addr := fs.String("addr", "localhost:8080", "address to serve the web UI on")
+	sessionPath := fs.String("session", "", "session file to reopen and keep saved with the current images and options")
+	fs.Parse(args)
+	configureLogging()
+
+	s := &uiServer{sessionPath: *sessionPath}
+	if *sessionPath != "" {
+		session, err := loadSession(*sessionPath)
+		if err == nil {
+			err = s.restore(session)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			logger.Fatal(err)
+		}
+	}
+	s.serve(*addr)
+}
+
+// liveMain serves the UI on images decoded once from the command line, with
+// the form preset from its flags, so every option change re-renders from
+// memory instead of rerunning the command.
+func liveMain(addr string, opts Options, paths []string, options map[string]string) {
+	s := &uiServer{options: options}
+	for _, path := range paths {
+		img, err := opts.loadImage(path)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		s.nextID++
+		s.images = append(s.images, uploadedImage{s.nextID, filepath.Base(path), img, nil})
+	}
+	s.serve(addr)
+}
+
+func (s *uiServer) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/images", s.handleImages)
+	mux.HandleFunc("/upload", s.handleUpload)
+	mux.HandleFunc("/remove", s.handleRemove)
+	mux.HandleFunc("/render", s.handleRender)
+	mux.HandleFunc("/session", s.handleSession)
+
+	logger.Infof("Serving the collage UI on http://%s/", addr)
+	logger.Fatal(http.ListenAndServe(addr, mux))
+}
+
+func (s *uiServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(uiPage))
+}
+
+func (s *uiServer) handleImages(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.images)
+}
+
+func (s *uiServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, fh := range r.MultipartForm.File["file"] {
+		f, err := fh.Open()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		img, err := decodeImage(data)
+		if err != nil {
+			http.Error(w, fh.Filename+": "+err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		s.mu.Lock()
+		s.nextID++
+		s.images = append(s.images, uploadedImage{s.nextID, fh.Filename, img, data})
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	err := s.persist()
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.handleImages(w, r)
+}
+
+func (s *uiServer) handleRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	s.mu.Lock()
+	for i, u := range s.images {
+		if u.ID == id {
+			s.images = append(s.images[:i], s.images[i+1:]...)
+			break
+		}
+	}
+	err := s.persist()
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.handleImages(w, r)
+}
+
+// handleSession returns the saved UI options on GET and stores the posted
+// form values as the new options on POST.
+func (s *uiServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.options = make(map[string]string)
+		for key := range r.PostForm {
+			s.options[key] = r.PostForm.Get(key)
+		}
+		if err := s.persist(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.options)
+}
+
+func (s *uiServer) handleRender(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	images := make([]image.Image, len(s.images))
+	for i, u := range s.images {
+		images[i] = u.img
+	}
+	s.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	output, err := renderForm(r.Form, images)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, output.value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>imagecollager</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+#side { width: 280px; padding: 16px; background: #f3f3f3; overflow-y: auto; }
+#main { flex: 1; display: flex; align-items: center; justify-content: center; background: #333; }
+#main img { max-width: 95%; max-height: 95%; background: repeating-conic-gradient(#777 0 25%, #888 0 50%) 0 0 / 16px 16px; }
+#drop { border: 2px dashed #999; padding: 24px; text-align: center; margin-bottom: 12px; }
+#drop.over { border-color: #06c; background: #e8f0ff; }
+label { display: block; margin: 8px 0 2px; font-size: 13px; }
+select, input { width: 100%; box-sizing: border-box; }
+#files { list-style: none; padding: 0; font-size: 12px; }
+#files button { float: right; }
+#error { color: #c00; font-size: 12px; }
+a.button { display: block; text-align: center; margin-top: 16px; padding: 8px; background: #06c; color: #fff; text-decoration: none; }
+</style>
+</head>
+<body>
+<div id="side">
+<div id="drop">Drop images here<br>or <input type="file" id="picker" multiple accept="image/*"></div>
+<form id="opts">
+<label>Layout</label>
+<select name="layout"><option>grid</option><option>text</option><option>compare</option></select>
+<label>Shape</label>
+<select name="shape"><option>Rectangle</option><option>Circle</option></select>
+<label>Rows (images per row for compare)</label>
+<input name="rows" type="number" min="1" placeholder="auto">
+<label>Packing</label>
+<select name="pack"><option>index</option><option>greedy</option><option>balanced</option></select>
+<label>Width</label>
+<input name="width" type="number" min="100" value="800">
+<label>Padding</label>
+<input name="padding" type="number" min="0" placeholder="auto">
+<label>Filter (grayscale, sepia or tint:#rrggbb)</label>
+<input name="filter" placeholder="none">
+<label>Normalize exposure</label>
+<select name="normalize"><option value="">off</option><option>mean</option><option>levels</option></select>
+<label>Rotation (angle, list or min:max)</label>
+<input name="rotate" placeholder="e.g. -8:8">
+<label>Seed</label>
+<input name="seed" type="number" value="1">
+<label>Text (text layout)</label>
+<input name="text" value="2024">
+</form>
+<a class="button" id="download" download="collage.png">Download PNG</a>
+<div id="error"></div>
+<ul id="files"></ul>
+</div>
+<div id="main"><img id="preview" alt=""></div>
+<script>
+const form = document.getElementById('opts');
+let timer = null;
+
+function listFiles(images) {
+  const ul = document.getElementById('files');
+  ul.innerHTML = '';
+  (images || []).forEach(im => {
+    const li = document.createElement('li');
+    li.textContent = im.name;
+    const b = document.createElement('button');
+    b.textContent = 'x';
+    b.onclick = () => fetch('/remove?id=' + im.id, {method: 'POST'}).then(r => r.json()).then(listFiles).then(refresh);
+    li.appendChild(b);
+    ul.appendChild(li);
+  });
+}
+
+function upload(files) {
+  const data = new FormData();
+  for (const f of files) data.append('file', f);
+  return fetch('/upload', {method: 'POST', body: data}).then(r => r.ok ? r.json() : r.text().then(t => { throw new Error(t); }))
+    .then(listFiles).then(refresh).catch(e => document.getElementById('error').textContent = e.message);
+}
+
+function refresh() {
+  clearTimeout(timer);
+  timer = setTimeout(() => {
+    const q = new URLSearchParams(new FormData(form));
+    fetch('/session', {method: 'POST', body: q});
+    fetch('/render?' + q).then(r => r.ok ? r.blob() : r.text().then(t => { throw new Error(t); })).then(blob => {
+      const url = URL.createObjectURL(blob);
+      document.getElementById('preview').src = url;
+      document.getElementById('download').href = url;
+      document.getElementById('error').textContent = '';
+    }).catch(e => document.getElementById('error').textContent = e.message);
+  }, 250);
+}
+
+const drop = document.getElementById('drop');
+drop.ondragover = e => { e.preventDefault(); drop.classList.add('over'); };
+drop.ondragleave = () => drop.classList.remove('over');
+drop.ondrop = e => { e.preventDefault(); drop.classList.remove('over'); upload(e.dataTransfer.files); };
+document.getElementById('picker').onchange = e => upload(e.target.files);
+form.oninput = refresh;
+fetch('/session').then(r => r.json()).then(options => {
+  for (const [name, value] of Object.entries(options || {})) {
+    if (form.elements[name]) form.elements[name].value = value;
+  }
+}).then(() => fetch('/images')).then(r => r.json()).then(listFiles).then(refresh);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type VariantGroup struct {
+	name     string
+	variants map[string]image.Image
+}
+
+// splitVariant splits a file name such as "hero_b.png" into its base name
+// ("hero") and variant suffix ("b") at the last occurrence of sep.
+func splitVariant(path string, sep string) (string, string) {
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	i := strings.LastIndex(stem, sep)
+	if i < 0 {
+		return stem, ""
+	}
+	return stem[:i], stem[i+len(sep):]
+}
+
+// groupVariants groups images sharing a base name, in order of first
+// appearance, and returns the sorted union of variant names as columns.
+func groupVariants(paths []string, images []image.Image, sep string) ([]VariantGroup, []string) {
+	var groups []VariantGroup
+	index := make(map[string]int)
+	seen := make(map[string]bool)
+	var variants []string
+
+	for i, path := range paths {
+		base, variant := splitVariant(path, sep)
+		g, ok := index[base]
+		if !ok {
+			g = len(groups)
+			index[base] = g
+			groups = append(groups, VariantGroup{base, make(map[string]image.Image)})
+		}
+		groups[g].variants[variant] = images[i]
+
+		if !seen[variant] {
+			seen[variant] = true
+			variants = append(variants, variant)
+		}
+	}
+
+	sort.Strings(variants)
+	return groups, variants
+}
+
+// makeVariantsSheet renders one row per group with its base name on the
+// left and one column per variant, labeled above the first row.
+func makeVariantsSheet(opts Options, groups []VariantGroup, variants []string) *MyImage {
+	padding := 1
+	labelWidth := 0
+	for _, g := range groups {
+		if w := textWidth(g.name); w > labelWidth {
+			labelWidth = w
+		}
+	}
+	labelWidth += 8
+	headerHeight := labelHeight + 6
+
+	cell := (opts.width - labelWidth - (len(variants)+1)*padding) / len(variants)
+
+	rows := make([][]image.Image, len(groups))
+	rowHeights := make([]int, len(groups))
+	totalHeight := headerHeight + padding
+	for r, g := range groups {
+		rows[r] = make([]image.Image, len(variants))
+		for c, v := range variants {
+			img, ok := g.variants[v]
+			if !ok {
+				continue
+			}
+			rows[r][c] = resample(img, uint(cell), 0)
+			if h := Height(rows[r][c]); h > rowHeights[r] {
+				rowHeights[r] = h
+			}
+		}
+		totalHeight += rowHeights[r] + padding
+	}
+
+	rectangleEnd := image.Point{labelWidth + len(variants)*(cell+padding) + padding, totalHeight}
+	output := opts.newCanvas(image.Rectangle{image.ZP, rectangleEnd})
+
+	for c, v := range variants {
+		x := labelWidth + padding + c*(cell+padding) + (cell-textWidth(v))/2
+		output.drawString(v, x, 3+labelFace.Ascent, color.White)
+	}
+
+	sp_y := headerHeight + padding
+	for r, g := range groups {
+		output.drawString(g.name, 4, sp_y+(rowHeights[r]-labelHeight)/2+labelFace.Ascent, color.White)
+		for c, tile := range rows[r] {
+			if tile == nil {
+				continue
+			}
+			output.drawRaw(tile, image.Point{labelWidth + padding + c*(cell+padding), sp_y + (rowHeights[r]-Height(tile))/2})
+		}
+		sp_y += rowHeights[r] + padding
+	}
+
+	return &output
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var videoExtensions = map[string]bool{
+	".avi": true, ".m4v": true, ".mkv": true, ".mov": true,
+	".mp4": true, ".mpg": true, ".webm": true,
+}
+
+// splitVideoPath separates a "clip.mp4#t=12.5" frame path, using the media
+// fragment syntax, into the file and seconds. hasTime is false for a bare
+// video path.
+func splitVideoPath(path string) (file string, seconds float64, hasTime bool, ok bool) {
+	file = path
+	if i := strings.LastIndex(path, "#t="); i >= 0 {
+		t, err := strconv.ParseFloat(path[i+3:], 64)
+		if err == nil {
+			file, seconds, hasTime = path[:i], t, true
+		}
+	}
+	return file, seconds, hasTime, videoExtensions[strings.ToLower(filepath.Ext(file))]
+}
+
+func runFFmpeg(name string, args ...string) ([]byte, error) {
+	p, err := exec.LookPath(name)
+	if err != nil {
+		return nil, errors.New("video: " + name + " not found in PATH")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(p, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New("video: " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	return out, nil
+}
+
+// pipeFFmpeg runs name with args and feeds its standard input whatever
+// write writes, as for encoding raw frames.
+func pipeFFmpeg(write func(w io.Writer) error, name string, args ...string) error {
+	p, err := exec.LookPath(name)
+	if err != nil {
+		return errors.New("video: " + name + " not found in PATH")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(p, args...)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.New("video: " + err.Error())
+	}
+	werr := write(stdin)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return errors.New("video: " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	return werr
+}
+
+func videoDuration(file string) (float64, error) {
+	out, err := runFFmpeg("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", file)
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || d <= 0 {
+		return 0, errors.New("video: no duration reported for " + file)
+	}
+	return d, nil
+}
+
+// expandVideos replaces every bare video argument with n frame paths spaced
+// evenly through the clip, each at the middle of its slice so the first and
+// last frames are not black leaders.
+func expandVideos(paths []string, n int) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		file, _, hasTime, ok := splitVideoPath(path)
+		if !ok || hasTime {
+			expanded = append(expanded, path)
+			continue
+		}
+		duration, err := videoDuration(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for i := 0; i < n; i++ {
+			t := duration * (float64(i) + 0.5) / float64(n)
+			expanded = append(expanded, fmt.Sprintf("%s#t=%.3f", file, t))
+		}
+	}
+	return expanded, nil
+}
+
+// extractFrame decodes the frame at the path's timestamp, or the first frame
+// of a bare video path, as PNG through a pipe.
+func extractFrame(path string) (image.Image, error) {
+	file, seconds, _, _ := splitVideoPath(path)
+	out, err := runFFmpeg("ffmpeg", "-v", "error", "-ss", strconv.FormatFloat(seconds, 'f', 3, 64), "-i", file,
+		"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-")
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("video: no frame at %s", formatTimestamp(seconds))
+	}
+	return decodeImage(out)
+}
+
+func formatTimestamp(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// videoCaption labels a frame tile with its timestamp, and is nil for
+// anything that is not a frame path.
+func videoCaption(path string) []string {
+	_, seconds, hasTime, ok := splitVideoPath(path)
+	if !ok || !hasTime {
+		return nil
+	}
+	return []string{formatTimestamp(seconds)}
+}
@@ -0,0 +1,232 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"math/rand"
+	"runtime"
+
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// maxViewerSize bounds the initial window; larger collages are shown
+// scaled down until the window is resized.
+const maxViewerSize = 1200
+
+const viewerHelp = "drag: swap  r: shuffle  c: shape  l/L: rows  +/-: padding  s: save  q: close"
+
+func init() {
+	// GLFW must be driven from the main thread.
+	runtime.LockOSThread()
+}
+
+// viewer shows a grid collage in a window and lets tiles be dragged onto
+// each other to swap them, re-rendering after every drop. Keys change the
+// shape, row count and padding in place and save the current collage.
+type viewer struct {
+	opts     Options
+	images   []image.Image
+	collage  *MyImage
+	savePath string
+
+	window   *glfw.Window
+	texture  uint32
+	dragFrom int
+}
+
+// viewCollage opens the viewer on images and returns the collage as
+// arranged when the window was closed. Pressing s writes it to savePath.
+func viewCollage(opts Options, images []image.Image, savePath string) (*MyImage, error) {
+	if err := glfw.Init(); err != nil {
+		return nil, err
+	}
+	defer glfw.Terminate()
+
+	v := &viewer{opts: opts, images: images, savePath: savePath, dragFrom: -1}
+	v.render()
+	// The first render sorted images tallest first; keep that order from
+	// here on so a swap moves exactly the two tiles involved.
+	v.opts.keepOrder = true
+
+	w, h := Width(v.collage), Height(v.collage)
+	if scale := float64(maxViewerSize) / math.Max(float64(w), float64(h)); scale < 1 {
+		w, h = int(float64(w)*scale), int(float64(h)*scale)
+	}
+	glfw.WindowHint(glfw.Resizable, glfw.True)
+	window, err := glfw.CreateWindow(w, h, "imagecollager: "+viewerHelp, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer window.Destroy()
+	window.MakeContextCurrent()
+	if err := gl.Init(); err != nil {
+		return nil, err
+	}
+	v.window = window
+
+	gl.GenTextures(1, &v.texture)
+	defer gl.DeleteTextures(1, &v.texture)
+	v.upload()
+
+	hand, arrow := glfw.CreateStandardCursor(glfw.HandCursor), glfw.CreateStandardCursor(glfw.ArrowCursor)
+	window.SetMouseButtonCallback(func(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+		if button != glfw.MouseButtonLeft {
+			return
+		}
+		switch action {
+		case glfw.Press:
+			v.dragFrom = v.tileAt(w.GetCursorPos())
+			if v.dragFrom >= 0 {
+				w.SetCursor(hand)
+			}
+		case glfw.Release:
+			if to := v.tileAt(w.GetCursorPos()); v.dragFrom >= 0 && to >= 0 && to != v.dragFrom {
+				v.images[v.dragFrom], v.images[to] = v.images[to], v.images[v.dragFrom]
+				v.render()
+				v.upload()
+			}
+			v.dragFrom = -1
+			w.SetCursor(arrow)
+		}
+	})
+	window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		if action == glfw.Release {
+			return
+		}
+		v.key(key, mods)
+	})
+	window.SetRefreshCallback(func(w *glfw.Window) {
+		v.draw()
+	})
+
+	for !window.ShouldClose() {
+		v.draw()
+		glfw.WaitEvents()
+	}
+	return v.collage, nil
+}
+
+func (v *viewer) key(key glfw.Key, mods glfw.ModifierKey) {
+	padding := v.opts.tilePadding()
+	switch key {
+	case glfw.KeyR:
+		rand.Shuffle(len(v.images), func(i, j int) {
+			v.images[i], v.images[j] = v.images[j], v.images[i]
+		})
+	case glfw.KeyC:
+		if v.opts.shape == CircleShape {
+			v.opts.shape = RectangleShape
+		} else {
+			v.opts.shape = CircleShape
+		}
+	case glfw.KeyL:
+		n := len(v.images)
+		if mods&glfw.ModShift != 0 {
+			v.opts.rows = (v.opts.rows+n-2)%n + 1
+		} else {
+			v.opts.rows = v.opts.rows%n + 1
+		}
+	case glfw.KeyEqual, glfw.KeyKPAdd:
+		v.opts.padding, v.opts.paddingSet = padding+1, true
+	case glfw.KeyMinus, glfw.KeyKPSubtract:
+		if padding == 0 {
+			return
+		}
+		v.opts.padding, v.opts.paddingSet = padding-1, true
+	case glfw.KeyS:
+		if err := saveImage(v.savePath, v.opts.printPage(v.opts.borderPage(v.collage)).value, v.opts.encoding); err != nil {
+			logger.Errorf("%v", err)
+			return
+		}
+		v.window.SetTitle(fmt.Sprintf("imagecollager: saved %s", v.savePath))
+		return
+	case glfw.KeyQ, glfw.KeyEscape:
+		v.window.SetShouldClose(true)
+		return
+	default:
+		return
+	}
+	v.render()
+	v.upload()
+	v.window.SetTitle(fmt.Sprintf("imagecollager: %s, %d rows, padding %d", v.opts.shape, v.opts.rows, v.opts.tilePadding()))
+}
+
+func (v *viewer) render() {
+	v.opts.placements = make(map[image.Image]image.Rectangle)
+	v.collage = makeImageCollage(v.opts, v.images...)
+}
+
+func (v *viewer) upload() {
+	rgba, ok := v.collage.value.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(v.collage.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), v.collage.value, image.ZP, draw.Src)
+	}
+	gl.BindTexture(gl.TEXTURE_2D, v.texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(rgba.Rect.Dx()), int32(rgba.Rect.Dy()), 0,
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rgba.Pix))
+}
+
+// viewport is where the collage sits in a width x height window: as large
+// as fits while keeping its aspect ratio, centered.
+func (v *viewer) viewport(width int, height int) (scale float64, offset image.Point) {
+	scale = math.Min(float64(width)/float64(Width(v.collage)), float64(height)/float64(Height(v.collage)))
+	offset = image.Point{
+		(width - int(float64(Width(v.collage))*scale)) / 2,
+		(height - int(float64(Height(v.collage))*scale)) / 2,
+	}
+	return scale, offset
+}
+
+func (v *viewer) draw() {
+	fw, fh := v.window.GetFramebufferSize()
+	gl.Viewport(0, 0, int32(fw), int32(fh))
+	gl.ClearColor(0.2, 0.2, 0.2, 1)
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+
+	gl.MatrixMode(gl.PROJECTION)
+	gl.LoadIdentity()
+	gl.Ortho(0, float64(fw), float64(fh), 0, -1, 1)
+	scale, offset := v.viewport(fw, fh)
+	x0, y0 := float32(offset.X), float32(offset.Y)
+	x1, y1 := x0+float32(float64(Width(v.collage))*scale), y0+float32(float64(Height(v.collage))*scale)
+
+	gl.Enable(gl.TEXTURE_2D)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.BindTexture(gl.TEXTURE_2D, v.texture)
+	gl.Begin(gl.QUADS)
+	gl.TexCoord2f(0, 0)
+	gl.Vertex2f(x0, y0)
+	gl.TexCoord2f(1, 0)
+	gl.Vertex2f(x1, y0)
+	gl.TexCoord2f(1, 1)
+	gl.Vertex2f(x1, y1)
+	gl.TexCoord2f(0, 1)
+	gl.Vertex2f(x0, y1)
+	gl.End()
+	v.window.SwapBuffers()
+}
+
+// tileAt maps a cursor position in window coordinates to the index of the
+// tile under it, or -1 between tiles.
+func (v *viewer) tileAt(x float64, y float64) int {
+	ww, wh := v.window.GetSize()
+	scale, offset := v.viewport(ww, wh)
+	p := image.Point{int((x - float64(offset.X)) / scale), int((y - float64(offset.Y)) / scale)}
+	for i, img := range v.images {
+		if p.In(v.opts.placements[img]) {
+			return i
+		}
+	}
+	return -1
+}
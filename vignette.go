@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// vignette darkens img radially from its center. At distance radius from the
+// center the darkening reaches strength (0-1); falloff shapes the curve, with
+// higher values keeping more of the middle untouched.
+func vignette(img image.Image, strength float64, falloff float64, radius float64) image.Image {
+	b := img.Bounds()
+	out := image.NewNRGBA64(b)
+	cx, cy := float64(b.Min.X+b.Max.X)/2, float64(b.Min.Y+b.Max.Y)/2
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy) / radius
+			k := math.Max(0, 1-strength*math.Pow(math.Min(d, 1), falloff))
+
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			out.SetNRGBA64(x, y, color.NRGBA64{uint16(float64(c.R) * k), uint16(float64(c.G) * k), uint16(float64(c.B) * k), c.A})
+		}
+	}
+	return out
+}
+
+// vignetteTile applies the configured vignette to a scaled tile. Circle
+// tiles reach full strength at the circle's edge, rectangles at the corners.
+func (o Options) vignetteTile(tile image.Image, shape ImageShape) image.Image {
+	if o.vignette <= 0 {
+		return tile
+	}
+	radius := math.Hypot(float64(Width(tile)), float64(Height(tile))) / 2
+	if shape == CircleShape {
+		radius = math.Min(float64(Width(tile)), float64(Height(tile))) / 2
+	}
+	return vignette(tile, o.vignette, o.vignetteFalloff, radius)
+}
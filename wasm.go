@@ -0,0 +1,85 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/url"
+	"strconv"
+	"syscall/js"
+)
+
+// main registers renderCollage on the global object and keeps the module
+// alive for calls from JavaScript. Build with
+//
+//	GOOS=js GOARCH=wasm go build -o imagecollager.wasm
+//
+// and load it with the wasm_exec.js shipped in $(go env GOROOT)/lib/wasm
+// (misc/wasm before Go 1.24).
+func main() {
+	js.Global().Set("renderCollage", js.FuncOf(renderCollage))
+	select {}
+}
+
+// renderCollage(images, options) takes an array of encoded images as
+// Uint8Arrays and an object with the web UI's fields (layout, shape, rows,
+// width, padding, pack, filter, normalize, rotate, seed, text), and returns the
+// collage as PNG bytes in a Uint8Array, or an Error.
+func renderCollage(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return jsError(fmt.Errorf("renderCollage: expected (images, options)"))
+	}
+
+	images := make([]image.Image, args[0].Length())
+	for i := range images {
+		data := make([]byte, args[0].Index(i).Length())
+		js.CopyBytesToGo(data, args[0].Index(i))
+		img, err := decodeImage(data)
+		if err != nil {
+			return jsError(fmt.Errorf("renderCollage: image %d: %v", i, err))
+		}
+		images[i] = img
+	}
+
+	form := url.Values{}
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		keys := js.Global().Get("Object").Call("keys", args[1])
+		for i := 0; i < keys.Length(); i++ {
+			key := keys.Index(i).String()
+			form.Set(key, jsString(args[1].Get(key)))
+		}
+	}
+
+	output, err := renderForm(form, images)
+	if err != nil {
+		return jsError(err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, output.value); err != nil {
+		return jsError(err)
+	}
+	out := js.Global().Get("Uint8Array").New(buf.Len())
+	js.CopyBytesToJS(out, buf.Bytes())
+	return out
+}
+
+// jsString formats option values the way they would arrive from a form.
+func jsString(v js.Value) string {
+	switch v.Type() {
+	case js.TypeNumber:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case js.TypeBoolean:
+		return strconv.FormatBool(v.Bool())
+	case js.TypeUndefined, js.TypeNull:
+		return ""
+	}
+	return v.String()
+}
+
+func jsError(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}